@@ -0,0 +1,460 @@
+package bnf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type DiagSeverity int
+
+const (
+	SeverityError DiagSeverity = iota
+	SeverityWarning
+)
+
+var DiagSeverityName = map[DiagSeverity]string{
+	SeverityError: "error",
+	SeverityWarning: "warning",
+}
+
+// Stable codes of the diagnostics. Do not renumber them, the CI tooling matches on them.
+const (
+	CodeSyntax = "E0001"
+	CodeUnknownDirective = "E0002"
+	CodeInclude = "E0003"
+	CodeRedefinition = "E0004"
+	CodeUndefinedIncAlternative = "E0005"
+	CodeUndefinedSymbol = "E0006"
+	CodeUnsupportedException = "E0007"
+	CodeNonProductive = "E0008"
+	CodeProfile = "E0009"
+	CodeNoMatch = "E0010"
+	CodeByteValue = "E0011"
+	CodeUnusedSymbol = "W0001"
+	CodeInfiniteExpectedSize = "W0002"
+)
+
+var DiagCodeDescription = map[string]string{
+	CodeSyntax: "The grammar file could not be lexed or parsed",
+	CodeUnknownDirective: "Unknown @directive",
+	CodeInclude: "The file or the @include could not be read",
+	CodeRedefinition: "The rule is defined more than once",
+	CodeUndefinedIncAlternative: "Incremental alternative is applied to a rule that is not defined",
+	CodeUndefinedSymbol: "The symbol is used but never defined",
+	CodeUnsupportedException: "The exception can not be turned into a set of characters",
+	CodeNonProductive: "The rule can never produce a finite message",
+	CodeProfile: "The -profile could not be read or applied",
+	CodeNoMatch: "The -match input is not derived from the -entry symbol",
+	CodeByteValue: "The value does not fit in a byte of -bytes",
+	CodeUnusedSymbol: "The rule is not reachable from the -entry symbol",
+	CodeInfiniteExpectedSize: "The rule expands into infinitely large messages on average",
+}
+
+type DiagNote struct {
+	Loc Loc
+	Message string
+}
+
+type DiagErr struct {
+	Loc Loc
+	Severity DiagSeverity
+	// One of the Code* constants. Empty means CodeSyntax.
+	Code string
+	// Width of the span under the caret. 0 means a single caret.
+	Len int
+	Err error
+	Help string
+	Notes []DiagNote
+	// The contents of the files the Loc and the Notes refer to, so Render can show the source lines
+	Sources Sources
+}
+
+func (err *DiagErr) Error() string {
+	// The diagnostics about the command line have no location
+	if len(err.Loc.FilePath) == 0 {
+		return fmt.Sprintf("%s: %s", strings.ToUpper(DiagSeverityName[err.Severity]), err.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", err.Loc, strings.ToUpper(DiagSeverityName[err.Severity]), err.Err)
+}
+
+func (err *DiagErr) GetCode() string {
+	if len(err.Code) == 0 {
+		return CodeSyntax
+	}
+	return err.Code
+}
+
+// Contents of the files by their paths, so the diagnostics can show the source lines. They are kept
+// by the Grammar, the Profile and the diagnostics referring to them rather than globally, so they are
+// released together with them.
+type Sources map[string][]rune
+
+// The union of the sources, the later ones win
+func MergeSources(sources ...Sources) Sources {
+	result := Sources{}
+	for _, s := range sources {
+		for filePath, content := range s {
+			result[filePath] = content
+		}
+	}
+	return result
+}
+
+// Attaches the sources to the diagnostics that don't have them yet
+func (errs Errors) WithSources(sources Sources) Errors {
+	for _, err := range errs {
+		if err.Sources == nil {
+			err.Sources = sources
+		}
+	}
+	return errs
+}
+
+// The same for a single error, which becomes a *DiagErr if it is not one
+func WithSources(err error, sources Sources) error {
+	if err == nil {
+		return nil
+	}
+	if errs, ok := err.(Errors); ok {
+		return errs.WithSources(sources)
+	}
+	diag := AsDiagErr(err)
+	if diag.Sources == nil {
+		diag.Sources = sources
+	}
+	return diag
+}
+
+func (sources Sources) Line(loc Loc) (line []rune, ok bool) {
+	content, ok := sources[loc.FilePath]
+	if !ok {
+		return
+	}
+	begin := 0
+	for row := 0; row < loc.Row; row += 1 {
+		for begin < len(content) && content[begin] != '\n' {
+			begin += 1
+		}
+		if begin >= len(content) {
+			ok = false
+			return
+		}
+		begin += 1
+	}
+	end := begin
+	for end < len(content) && content[end] != '\n' {
+		end += 1
+	}
+	line = content[begin:end]
+	ok = true
+	return
+}
+
+// Renders the source line of the loc with the carets under the span
+//
+//   |
+// 3 | foo = "a" $ "b"
+//   |           ^
+func (sources Sources) RenderSnippet(loc Loc, length int) string {
+	line, ok := sources.Line(loc)
+	if !ok {
+		return ""
+	}
+	if length <= 0 {
+		length = 1
+	}
+	number := fmt.Sprintf("%d", loc.Row + 1)
+	gutter := strings.Repeat(" ", len(number))
+
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s |\n", gutter))
+	sb.WriteString(fmt.Sprintf("%s | %s\n", number, strings.TrimRight(string(line), "\r")))
+	sb.WriteString(fmt.Sprintf("%s | ", gutter))
+	for i := 0; i < loc.Col && i < len(line); i += 1 {
+		// Keep the tabs so the carets line up with the source
+		if line[i] == '\t' {
+			sb.WriteRune('\t')
+		} else {
+			sb.WriteRune(' ')
+		}
+	}
+	sb.WriteString(strings.Repeat("^", length))
+	sb.WriteRune('\n')
+	return sb.String()
+}
+
+func (err *DiagErr) Render() string {
+	sb := strings.Builder{}
+	sb.WriteString(err.Error())
+	sb.WriteRune('\n')
+	sb.WriteString(err.Sources.RenderSnippet(err.Loc, err.Len))
+	if len(err.Help) > 0 {
+		sb.WriteString(fmt.Sprintf("%s = help: %s\n", strings.Repeat(" ", len(fmt.Sprintf("%d", err.Loc.Row + 1))), err.Help))
+	}
+	for _, note := range err.Notes {
+		sb.WriteString(fmt.Sprintf("%s: NOTE: %s\n", note.Loc, note.Message))
+		sb.WriteString(err.Sources.RenderSnippet(note.Loc, 0))
+	}
+	return sb.String()
+}
+
+// All the diagnostics found while loading a grammar
+type Errors []*DiagErr
+
+func (errs Errors) Error() string {
+	lines := []string{}
+	for _, err := range errs {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func AsDiagErr(err error) *DiagErr {
+	if diag, ok := err.(*DiagErr); ok {
+		return diag
+	}
+	return &DiagErr{Err: err}
+}
+
+func (errs *Errors) Add(err error) {
+	*errs = append(*errs, AsDiagErr(err))
+}
+
+type JsonDiagNote struct {
+	File string `json:"file"`
+	Line int `json:"line"`
+	Column int `json:"column"`
+	Message string `json:"message"`
+}
+
+// The location fields are omitted for the diagnostics without a location, like the ones about the command line
+type JsonDiag struct {
+	File string `json:"file,omitempty"`
+	Line int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+	Length int `json:"length,omitempty"`
+	Severity string `json:"severity"`
+	Code string `json:"code"`
+	Message string `json:"message"`
+	Help string `json:"help,omitempty"`
+	Notes []JsonDiagNote `json:"notes,omitempty"`
+}
+
+func ErrorsAsJson(errs Errors) []JsonDiag {
+	result := []JsonDiag{}
+	for _, diag := range errs {
+		entry := JsonDiag{
+			Severity: DiagSeverityName[diag.Severity],
+			Code: diag.GetCode(),
+			Message: diag.Err.Error(),
+			Help: diag.Help,
+		}
+		if len(diag.Loc.FilePath) > 0 {
+			entry.File = diag.Loc.FilePath
+			entry.Line = diag.Loc.Row + 1
+			entry.Column = diag.Loc.Col + 1
+			entry.Length = diag.Len
+			if entry.Length <= 0 {
+				entry.Length = 1
+			}
+		}
+		for _, note := range diag.Notes {
+			entry.Notes = append(entry.Notes, JsonDiagNote{
+				File: note.Loc.FilePath,
+				Line: note.Loc.Row + 1,
+				Column: note.Loc.Col + 1,
+				Message: note.Message,
+			})
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+type SarifMessage struct {
+	Text string `json:"text"`
+}
+
+type SarifRegion struct {
+	StartLine int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndColumn int `json:"endColumn"`
+}
+
+type SarifArtifactLocation struct {
+	Uri string `json:"uri"`
+}
+
+type SarifPhysicalLocation struct {
+	ArtifactLocation SarifArtifactLocation `json:"artifactLocation"`
+	Region SarifRegion `json:"region"`
+}
+
+type SarifLocation struct {
+	PhysicalLocation SarifPhysicalLocation `json:"physicalLocation"`
+	Message *SarifMessage `json:"message,omitempty"`
+}
+
+type SarifResult struct {
+	RuleId string `json:"ruleId"`
+	Level string `json:"level"`
+	Message SarifMessage `json:"message"`
+	// Empty for the diagnostics without a location
+	Locations []SarifLocation `json:"locations,omitempty"`
+	RelatedLocations []SarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type SarifRule struct {
+	Id string `json:"id"`
+	ShortDescription SarifMessage `json:"shortDescription"`
+}
+
+type SarifDriver struct {
+	Name string `json:"name"`
+	InformationUri string `json:"informationUri"`
+	Rules []SarifRule `json:"rules"`
+}
+
+type SarifTool struct {
+	Driver SarifDriver `json:"driver"`
+}
+
+type SarifRun struct {
+	Tool SarifTool `json:"tool"`
+	// Loc.Col counts runes rather than the UTF-16 code units SARIF assumes by default
+	ColumnKind string `json:"columnKind"`
+	Results []SarifResult `json:"results"`
+}
+
+type SarifLog struct {
+	Schema string `json:"$schema"`
+	Version string `json:"version"`
+	Runs []SarifRun `json:"runs"`
+}
+
+func SarifLocationOf(loc Loc, length int, message string) SarifLocation {
+	if length <= 0 {
+		length = 1
+	}
+	location := SarifLocation{
+		PhysicalLocation: SarifPhysicalLocation{
+			ArtifactLocation: SarifArtifactLocation{Uri: loc.FilePath},
+			Region: SarifRegion{
+				StartLine: loc.Row + 1,
+				StartColumn: loc.Col + 1,
+				EndColumn: loc.Col + 1 + length,
+			},
+		},
+	}
+	if len(message) > 0 {
+		location.Message = &SarifMessage{Text: message}
+	}
+	return location
+}
+
+func ErrorsAsSarif(errs Errors) SarifLog {
+	codes := []string{}
+	for code := range DiagCodeDescription {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	rules := []SarifRule{}
+	for _, code := range codes {
+		rules = append(rules, SarifRule{
+			Id: code,
+			ShortDescription: SarifMessage{Text: DiagCodeDescription[code]},
+		})
+	}
+
+	results := []SarifResult{}
+	for _, diag := range errs {
+		text := diag.Err.Error()
+		if len(diag.Help) > 0 {
+			text = fmt.Sprintf("%s (help: %s)", text, diag.Help)
+		}
+		result := SarifResult{
+			RuleId: diag.GetCode(),
+			Level: DiagSeverityName[diag.Severity],
+			Message: SarifMessage{Text: text},
+		}
+		if len(diag.Loc.FilePath) > 0 {
+			result.Locations = []SarifLocation{SarifLocationOf(diag.Loc, diag.Len, "")}
+		}
+		for _, note := range diag.Notes {
+			result.RelatedLocations = append(result.RelatedLocations, SarifLocationOf(note.Loc, 0, note.Message))
+		}
+		results = append(results, result)
+	}
+
+	return SarifLog{
+		Schema: "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []SarifRun{{
+			Tool: SarifTool{
+				Driver: SarifDriver{
+					Name: "bnfuzzer",
+					InformationUri: "https://github.com/rexim/bnfuzzer",
+					Rules: rules,
+				},
+			},
+			ColumnKind: "unicodeCodePoints",
+			Results: results,
+		}},
+	}
+}
+
+func EditDistance(a, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	prev := make([]int, len(rb) + 1)
+	curr := make([]int, len(rb) + 1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i += 1 {
+		curr[0] = i
+		for j := 1; j <= len(rb); j += 1 {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j] + 1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1] + 1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Finds the defined rule name closest to the given one by edit distance
+func SuggestSymbol(grammar map[string]Rule, name string) (suggestion string, ok bool) {
+	names := []string{}
+	for candidate := range grammar {
+		names = append(names, candidate)
+	}
+	sort.Strings(names)
+
+	best := len([]rune(name))/3 + 1
+	for _, candidate := range names {
+		distance := EditDistance(strings.ToLower(name), strings.ToLower(candidate))
+		if distance < best {
+			best = distance
+			suggestion = candidate
+			ok = true
+		}
+	}
+	return
+}
+
+func SymbolHelp(grammar map[string]Rule, name string) string {
+	if suggestion, ok := SuggestSymbol(grammar, name); ok {
+		return fmt.Sprintf("did you mean %s?", suggestion)
+	}
+	return ""
+}
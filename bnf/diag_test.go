@@ -0,0 +1,52 @@
+package bnf
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticsWithoutLocation(t *testing.T) {
+	errs := Errors{
+		&DiagErr{Code: CodeUndefinedSymbol, Err: fmt.Errorf("Symbol x is not defined")},
+		&DiagErr{Loc: Loc{FilePath: "test.abnf", Row: 1, Col: 2}, Len: 3, Err: fmt.Errorf("Unexpected token")},
+	}
+	json := ErrorsAsJson(errs)
+	if json[0].File != "" || json[0].Line != 0 || json[0].Column != 0 || json[0].Length != 0 {
+		t.Errorf("expected no location, got %+v", json[0])
+	}
+	if json[1].File != "test.abnf" || json[1].Line != 2 || json[1].Column != 3 || json[1].Length != 3 {
+		t.Errorf("expected test.abnf:2:3, got %+v", json[1])
+	}
+	results := ErrorsAsSarif(errs).Runs[0].Results
+	if len(results[0].Locations) != 0 {
+		t.Errorf("expected no locations, got %+v", results[0].Locations)
+	}
+	if len(results[1].Locations) != 1 || results[1].Locations[0].PhysicalLocation.ArtifactLocation.Uri != "test.abnf" {
+		t.Errorf("expected the location in test.abnf, got %+v", results[1].Locations)
+	}
+}
+
+// Every diagnostic shows the source it was found in, even if another source has the same path
+func TestRenderSources(t *testing.T) {
+	for _, content := range []string{"a = \"x\" )\n", "b = \"y\" )\n"} {
+		_, err := ParseString(content, "test.abnf", Options{})
+		errs, ok := err.(Errors)
+		if !ok || len(errs) == 0 {
+			t.Fatalf("%q: expected the errors, got %v", content, err)
+		}
+		if rendered := errs[0].Render(); !strings.Contains(rendered, "| "+strings.TrimSpace(content)) {
+			t.Errorf("%q: expected the source line in\n%s", content, rendered)
+		}
+	}
+
+	grammar := MustParse(t, "a = \"x\" \"y\"\n", "test.abnf")
+	rec, err := NewRecognizer(grammar, "a")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = rec.Match([]rune("xz"), "input")
+	if rendered := AsDiagErr(err).Render(); !strings.Contains(rendered, "| xz") || !strings.Contains(rendered, "| a = \"x\" \"y\"") {
+		t.Errorf("expected the input and the grammar lines in\n%s", rendered)
+	}
+}
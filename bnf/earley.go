@@ -0,0 +1,434 @@
+package bnf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A symbol of the productions of the Recognizer. Either a Nonterminal or a Terminal that
+// matches a single character: ExprString with one rune, ExprRange or ExprCharClass.
+type EarleySymbol struct {
+	// Index in Recognizer.Nonterminals. -1 for the terminals.
+	Nonterminal int
+	Terminal Expr
+}
+
+type Nonterminal struct {
+	// The rule the Nonterminal belongs to
+	Rule string
+	// The expression of the Rule the Nonterminal was compiled from
+	Expr Expr
+	// The Nonterminal stands for the whole Rule, not for a part of its body
+	IsRule bool
+	// The Nonterminal matches the optional iterations of a repetition. Its children that are
+	// Optional too match fewer iterations of the same repetition.
+	Optional bool
+	// The amount of symbols of an iteration of the repetition the Nonterminal was compiled from
+	IterationSize int
+	Productions []int
+	Nullable bool
+}
+
+type Production struct {
+	Head int
+	Body []EarleySymbol
+	// The index of the variant of the ExprAlternation the Production was compiled from. -1 otherwise.
+	Variant int
+}
+
+// Recognizes the inputs derived from the entry symbol with the Earley algorithm. It works with any
+// context-free grammar, including the left recursive and the ambiguous ones.
+//
+// The rules are compiled into the plain productions: every rule, alternation and repetition gets
+// its own Nonterminal, the strings become the sequences of the single character terminals. The
+// repetitions without the upper bound in the grammar, like `*a`, match any amount of iterations.
+type Recognizer struct {
+	Grammar *Grammar
+	Nonterminals []Nonterminal
+	Productions []Production
+	// The Nonterminals of the rules by their names
+	Rules map[string]int
+	Start int
+	// The values of the inputs are octets, so every one of them takes a single byte
+	Bytes bool
+}
+
+func NewRecognizer(grammar *Grammar, entry string) (rec *Recognizer, err error) {
+	rec = &Recognizer{
+		Grammar: grammar,
+		Rules: map[string]int{},
+	}
+	var errs Errors
+	rec.Start, errs = rec.CompileRule(entry, Loc{})
+	if len(errs) > 0 {
+		err = errs.WithSources(grammar.Sources)
+		return
+	}
+	rec.ComputeNullable()
+	return
+}
+
+func (rec *Recognizer) AddNonterminal(rule string, expr Expr) int {
+	rec.Nonterminals = append(rec.Nonterminals, Nonterminal{
+		Rule: rule,
+		Expr: expr,
+	})
+	return len(rec.Nonterminals) - 1
+}
+
+func (rec *Recognizer) AddProduction(head int, body []EarleySymbol, variant int) {
+	rec.Productions = append(rec.Productions, Production{
+		Head: head,
+		Body: body,
+		Variant: variant,
+	})
+	rec.Nonterminals[head].Productions = append(rec.Nonterminals[head].Productions, len(rec.Productions) - 1)
+}
+
+// The loc is where the rule is referred from, it is used only for the undefined rules
+func (rec *Recognizer) CompileRule(name string, loc Loc) (nt int, errs Errors) {
+	nt, ok := rec.Rules[name]
+	if ok {
+		return
+	}
+	rule, ok := rec.Grammar.Rules[name]
+	if !ok {
+		errs.Add(&DiagErr{
+			Loc: loc,
+			Len: len(name),
+			Code: CodeUndefinedSymbol,
+			Err: fmt.Errorf("Symbol %s is not defined", name),
+			Help: SymbolHelp(rec.Grammar.Rules, name),
+		})
+		return
+	}
+	nt = rec.AddNonterminal(name, rule.Body)
+	rec.Nonterminals[nt].IsRule = true
+	rec.Rules[name] = nt
+	// The alternatives of the rule become the productions of the rule itself, so the
+	// derivations refer to them by their index
+	if alt, ok := rule.Body.(ExprAlternation); ok {
+		for i, variant := range alt.Variants {
+			body, variantErrs := rec.CompileExpr(name, variant)
+			errs = append(errs, variantErrs...)
+			rec.AddProduction(nt, body, i)
+		}
+		return
+	}
+	body, bodyErrs := rec.CompileExpr(name, rule.Body)
+	errs = append(errs, bodyErrs...)
+	rec.AddProduction(nt, body, -1)
+	return
+}
+
+func Repeat(body []EarleySymbol, n uint) (result []EarleySymbol) {
+	for i := uint(0); i < n; i += 1 {
+		result = append(result, body...)
+	}
+	return
+}
+
+// Compiles the expr of the rule into a sequence of symbols
+func (rec *Recognizer) CompileExpr(rule string, expr Expr) (seq []EarleySymbol, errs Errors) {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		nt, symbolErrs := rec.CompileRule(expr.Name, expr.Loc)
+		errs = append(errs, symbolErrs...)
+		seq = append(seq, EarleySymbol{Nonterminal: nt})
+	case ExprString:
+		for _, x := range expr.Text {
+			seq = append(seq, EarleySymbol{
+				Nonterminal: -1,
+				Terminal: ExprString{
+					Loc: expr.Loc,
+					Text: []rune{x},
+					CaseInsensitive: expr.CaseInsensitive,
+				},
+			})
+		}
+	case ExprRange, ExprCharClass:
+		seq = append(seq, EarleySymbol{Nonterminal: -1, Terminal: expr})
+	case ExprConcat:
+		for _, element := range expr.Elements {
+			elementSeq, elementErrs := rec.CompileExpr(rule, element)
+			errs = append(errs, elementErrs...)
+			seq = append(seq, elementSeq...)
+		}
+	case ExprAlternation:
+		nt := rec.AddNonterminal(rule, expr)
+		for i, variant := range expr.Variants {
+			body, variantErrs := rec.CompileExpr(rule, variant)
+			errs = append(errs, variantErrs...)
+			rec.AddProduction(nt, body, i)
+		}
+		seq = append(seq, EarleySymbol{Nonterminal: nt})
+	case ExprRepetition:
+		body, bodyErrs := rec.CompileExpr(rule, expr.Body)
+		errs = append(errs, bodyErrs...)
+		nt := rec.AddNonterminal(rule, expr)
+		rec.Nonterminals[nt].IterationSize = len(body)
+		if expr.Unbounded {
+			// N = body{Lower} Tail, Tail = ε | Tail body. The left recursion keeps the Earley sets small.
+			tail := rec.AddNonterminal(rule, expr)
+			rec.Nonterminals[tail].Optional = true
+			rec.Nonterminals[tail].IterationSize = len(body)
+			rec.AddProduction(tail, nil, -1)
+			rec.AddProduction(tail, append([]EarleySymbol{{Nonterminal: tail}}, body...), -1)
+			rec.AddProduction(nt, append(Repeat(body, expr.Lower), EarleySymbol{Nonterminal: tail}), -1)
+		} else if expr.Upper > expr.Lower {
+			// N = body{Lower} T(Upper - Lower), T(k) = ε | body T(k - 1)
+			optional := -1
+			for k := expr.Lower; k < expr.Upper; k += 1 {
+				next := rec.AddNonterminal(rule, expr)
+				rec.Nonterminals[next].Optional = true
+				rec.Nonterminals[next].IterationSize = len(body)
+				rec.AddProduction(next, nil, -1)
+				if optional < 0 {
+					rec.AddProduction(next, append([]EarleySymbol{}, body...), -1)
+				} else {
+					rec.AddProduction(next, append(append([]EarleySymbol{}, body...), EarleySymbol{Nonterminal: optional}), -1)
+				}
+				optional = next
+			}
+			rec.AddProduction(nt, append(Repeat(body, expr.Lower), EarleySymbol{Nonterminal: optional}), -1)
+		} else {
+			rec.AddProduction(nt, Repeat(body, expr.Lower), -1)
+		}
+		seq = append(seq, EarleySymbol{Nonterminal: nt})
+	case ExprException:
+		errs.Add(&DiagErr{
+			Loc: expr.Loc,
+			Code: CodeUnsupportedException,
+			Err: fmt.Errorf("Exception can not be matched"),
+		})
+	default:
+		panic("unreachable")
+	}
+	return
+}
+
+func (rec *Recognizer) ComputeNullable() {
+	for changed := true; changed; {
+		changed = false
+		for _, prod := range rec.Productions {
+			if rec.Nonterminals[prod.Head].Nullable {
+				continue
+			}
+			nullable := true
+			for _, symbol := range prod.Body {
+				if symbol.Nonterminal < 0 || !rec.Nonterminals[symbol.Nonterminal].Nullable {
+					nullable = false
+					break
+				}
+			}
+			if nullable {
+				rec.Nonterminals[prod.Head].Nullable = true
+				changed = true
+			}
+		}
+	}
+}
+
+func MatchTerminal(terminal Expr, x rune) bool {
+	switch terminal := terminal.(type) {
+	case ExprString:
+		y := terminal.Text[0]
+		if terminal.CaseInsensitive {
+			return ToLowerASCII(x) == ToLowerASCII(y)
+		}
+		return x == y
+	case ExprRange:
+		return terminal.Lower <= x && x <= terminal.Upper
+	case ExprCharClass:
+		i := sort.Search(len(terminal.Ranges), func(i int) bool {
+			return terminal.Ranges[i].Upper >= x
+		})
+		return i < len(terminal.Ranges) && terminal.Ranges[i].Lower <= x
+	}
+	panic("unreachable")
+}
+
+// The production with the dot before the symbol Body[Dot], started at the position Origin of the input
+type EarleyItem struct {
+	Prod int
+	Dot int
+	Origin int
+}
+
+type EarleySet struct {
+	Items []EarleyItem
+	Seen map[EarleyItem]bool
+	// The items waiting for the Nonterminal to be completed at this position
+	Waiting map[int][]EarleyItem
+}
+
+// The Earley sets of the input. Sets[i] holds the items that are possible after i characters.
+type Chart struct {
+	Input []rune
+	Sets []EarleySet
+}
+
+func (rec *Recognizer) NextSymbol(item EarleyItem) (symbol EarleySymbol, ok bool) {
+	body := rec.Productions[item.Prod].Body
+	if item.Dot >= len(body) {
+		return
+	}
+	return body[item.Dot], true
+}
+
+func (chart *Chart) Add(rec *Recognizer, i int, item EarleyItem) {
+	set := &chart.Sets[i]
+	if set.Seen[item] {
+		return
+	}
+	set.Seen[item] = true
+	set.Items = append(set.Items, item)
+	if symbol, ok := rec.NextSymbol(item); ok && symbol.Nonterminal >= 0 {
+		set.Waiting[symbol.Nonterminal] = append(set.Waiting[symbol.Nonterminal], item)
+	}
+}
+
+func (rec *Recognizer) Parse(input []rune) (chart *Chart) {
+	chart = &Chart{
+		Input: input,
+		Sets: make([]EarleySet, len(input) + 1),
+	}
+	for i := range chart.Sets {
+		chart.Sets[i] = EarleySet{
+			Seen: map[EarleyItem]bool{},
+			Waiting: map[int][]EarleyItem{},
+		}
+	}
+	for _, prod := range rec.Nonterminals[rec.Start].Productions {
+		chart.Add(rec, 0, EarleyItem{Prod: prod})
+	}
+	for i := range chart.Sets {
+		// The set grows while it is processed
+		for j := 0; j < len(chart.Sets[i].Items); j += 1 {
+			item := chart.Sets[i].Items[j]
+			symbol, ok := rec.NextSymbol(item)
+			switch {
+			case !ok:
+				head := rec.Productions[item.Prod].Head
+				for _, waiting := range chart.Sets[item.Origin].Waiting[head] {
+					waiting.Dot += 1
+					chart.Add(rec, i, waiting)
+				}
+			case symbol.Nonterminal >= 0:
+				for _, prod := range rec.Nonterminals[symbol.Nonterminal].Productions {
+					chart.Add(rec, i, EarleyItem{Prod: prod, Origin: i})
+				}
+				// The nullable nonterminals may have been completed at this position already,
+				// before the item started waiting for them
+				if rec.Nonterminals[symbol.Nonterminal].Nullable {
+					item.Dot += 1
+					chart.Add(rec, i, item)
+				}
+			default:
+				if i < len(input) && MatchTerminal(symbol.Terminal, input[i]) {
+					item.Dot += 1
+					chart.Add(rec, i + 1, item)
+				}
+			}
+		}
+	}
+	return
+}
+
+func (rec *Recognizer) IsComplete(item EarleyItem) bool {
+	return item.Dot == len(rec.Productions[item.Prod].Body)
+}
+
+// Whether the whole input is derived from the start symbol
+func (rec *Recognizer) Accepted(chart *Chart) bool {
+	for _, item := range chart.Sets[len(chart.Input)].Items {
+		if item.Origin == 0 && rec.IsComplete(item) && rec.Productions[item.Prod].Head == rec.Start {
+			return true
+		}
+	}
+	return false
+}
+
+// The position after the longest prefix of the input that can still be continued into a match
+func (chart *Chart) Furthest() int {
+	furthest := 0
+	for i := range chart.Sets {
+		if len(chart.Sets[i].Items) > 0 {
+			furthest = i
+		}
+	}
+	return furthest
+}
+
+func LocOfIndex(content []rune, filePath string, index int) (loc Loc) {
+	loc.FilePath = filePath
+	for _, x := range content[:index] {
+		if x == '\n' {
+			loc.Row += 1
+			loc.Col = 0
+		} else {
+			loc.Col += 1
+		}
+	}
+	return
+}
+
+func DescribeChar(x rune) string {
+	if unicode.IsGraphic(x) && !unicode.IsSpace(x) {
+		return fmt.Sprintf("%q (%%x%02X)", x, x)
+	}
+	return fmt.Sprintf("%%x%02X", x)
+}
+
+// Checks that the whole input is derived from the entry symbol. Reports the furthest position the
+// input could be matched up to and the terminals expected there otherwise. The filePath is used only
+// for the diagnostics. The chart of the accepted input can be turned into the parse trees with Forest.
+func (rec *Recognizer) Match(input []rune, filePath string) (chart *Chart, err error) {
+	chart = rec.Parse(input)
+	if rec.Accepted(chart) {
+		return
+	}
+	furthest := chart.Furthest()
+	diag := &DiagErr{
+		Loc: LocOfIndex(input, filePath, furthest),
+		Code: CodeNoMatch,
+		// The notes refer to the grammar
+		Sources: MergeSources(rec.Grammar.Sources, Sources{filePath: input}),
+	}
+	if furthest < len(input) {
+		diag.Len = 1
+		diag.Err = fmt.Errorf("Unexpected %s", DescribeChar(input[furthest]))
+	} else {
+		diag.Err = fmt.Errorf("Unexpected end of input")
+	}
+
+	expected := []string{}
+	notes := map[string]DiagNote{}
+	for _, item := range chart.Sets[furthest].Items {
+		symbol, ok := rec.NextSymbol(item)
+		if !ok || symbol.Nonterminal >= 0 {
+			continue
+		}
+		terminal := symbol.Terminal.String()
+		if _, ok := notes[terminal]; !ok {
+			expected = append(expected, terminal)
+			notes[terminal] = DiagNote{
+				Loc: symbol.Terminal.GetLoc(),
+				Message: fmt.Sprintf("%s is expected by %s", terminal, rec.Nonterminals[rec.Productions[item.Prod].Head].Rule),
+			}
+		}
+	}
+	sort.Strings(expected)
+	if len(expected) > 0 {
+		diag.Help = fmt.Sprintf("Expected %s", strings.Join(expected, ", "))
+	} else {
+		diag.Help = "Expected the end of input"
+	}
+	for _, terminal := range expected {
+		diag.Notes = append(diag.Notes, notes[terminal])
+	}
+	err = diag
+	return
+}
@@ -0,0 +1,128 @@
+package bnf
+
+import (
+	"testing"
+)
+
+func TestRecognizer(t *testing.T) {
+	tests := []struct {
+		grammar string
+		entry string
+		input string
+		accepted bool
+	}{
+		{"a = \"x\" / \"y\"\n", "a", "y", true},
+		{"a = \"x\" / \"y\"\n", "a", "z", false},
+		{"a = %i\"ab\"\n", "a", "aB", true},
+		{"a = %s\"ab\"\n", "a", "aB", false},
+		{"a = 2*3\"x\"\n", "a", "x", false},
+		{"a = 2*3\"x\"\n", "a", "xxx", true},
+		{"a = 2*3\"x\"\n", "a", "xxxx", false},
+		{"a = *\"x\" \"y\"\n", "a", "y", true},
+		{"a = *\"x\" \"y\"\n", "a", "xxxxy", true},
+		{"a = a \"+\" a / DIGIT\n", "a", "1+2+3", true},
+		{"a = a \"+\" a / DIGIT\n", "a", "1+", false},
+		{"a = [ \"x\" ] b\nb = \"\" / \"y\"\n", "a", "", true},
+		{"a = %x41-43 / \"z\"\n", "a", "C", true},
+	}
+	for _, test := range tests {
+		grammar := MustParse(t, test.grammar, "test.abnf")
+		rec, err := NewRecognizer(grammar, test.entry)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if accepted := rec.Accepted(rec.Parse([]rune(test.input))); accepted != test.accepted {
+			t.Errorf("%q matching %q: expected %t, got %t", test.grammar, test.input, test.accepted, accepted)
+		}
+	}
+}
+
+func TestMatchReportsFurthest(t *testing.T) {
+	grammar := MustParse(t, "a = \"ab\" \"c\"\n", "test.abnf")
+	rec, err := NewRecognizer(grammar, "a")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = rec.Match([]rune("abx"), "input")
+	diag := AsDiagErr(err)
+	if err == nil || diag.Code != CodeNoMatch || diag.Loc.String() != "input:1:3" {
+		t.Fatalf("expected %s at input:1:3, got %v", CodeNoMatch, err)
+	}
+}
+
+func TestForest(t *testing.T) {
+	tests := []struct {
+		grammar string
+		input string
+		count int64
+	}{
+		{"a = \"x\"\n", "x", 1},
+		// Catalan numbers
+		{"a = a \"+\" a / \"x\"\n", "x+x+x", 2},
+		{"a = a \"+\" a / \"x\"\n", "x+x+x+x", 5},
+		{"a = a \"+\" a / \"x\"\n", "x+x+x+x+x", 14},
+		{"a = *b\nb = \"x\" / \"x\" \"x\"\n", "xxx", 3},
+		// The cyclic derivations are skipped
+		{"a = b / \"x\"\nb = a\n", "x", 1},
+	}
+	for _, test := range tests {
+		grammar := MustParse(t, test.grammar, "test.abnf")
+		rec, err := NewRecognizer(grammar, "a")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		chart, err := rec.Match([]rune(test.input), "input")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		forest := rec.Forest(chart)
+		if count := forest.Count(); count.Int64() != test.count {
+			t.Errorf("%q parsing %q: expected %d trees, got %s", test.grammar, test.input, test.count, count)
+		}
+		if trees := forest.Trees(0); int64(len(trees)) != test.count {
+			t.Errorf("%q parsing %q: expected %d trees, got %d", test.grammar, test.input, test.count, len(trees))
+		}
+	}
+}
+
+func TestTreeAlternative(t *testing.T) {
+	grammar := MustParse(t, "cmd = \"a\" / \"b\"\n", "test.abnf")
+	rec, err := NewRecognizer(grammar, "cmd")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	input := []rune("b")
+	chart, err := rec.Match(input, "input")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	tree := rec.TreeAsJson(rec.Forest(chart).Trees(1)[0], input)
+	if tree.Alternative == nil || *tree.Alternative != 1 {
+		t.Fatalf("expected the alternative 1, got %s", tree.SExpr())
+	}
+}
+
+// The trees of a span that skipped a cyclic derivation depend on the spans above it
+func TestForestCycleOrder(t *testing.T) {
+	counts := []int64{}
+	for _, c := range []string{"c = a / b\n", "c = b / a\n"} {
+		grammar := MustParse(t, c+"b = a\na = b / \"x\"\n", "test.abnf")
+		rec, err := NewRecognizer(grammar, "c")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		chart, err := rec.Match([]rune("x"), "input")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		forest := rec.Forest(chart)
+		count := forest.Count().Int64()
+		if trees := forest.Trees(0); int64(len(trees)) != count {
+			t.Errorf("%q: counted %d trees, extracted %d", c, count, len(trees))
+		}
+		counts = append(counts, count)
+	}
+	if counts[0] != 2 || counts[1] != 2 {
+		t.Fatalf("expected 2 trees for both orders of the alternatives, got %v", counts)
+	}
+}
@@ -0,0 +1,622 @@
+package bnf
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// The height of the expressions that can not produce a finite message
+const InfiniteHeight = math.MaxInt32
+
+// Computes the minimal derivation height of every rule, i.e. the least amount of nested symbols
+// needed to produce a message from it. The rules that never finish get InfiniteHeight.
+func MinHeights(grammar map[string]Rule) map[string]int {
+	heights := map[string]int{}
+	for name := range grammar {
+		heights[name] = InfiniteHeight
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, rule := range grammar {
+			height := MinHeightOfExpr(heights, rule.Body)
+			if height < heights[name] {
+				heights[name] = height
+				changed = true
+			}
+		}
+	}
+	return heights
+}
+
+func MinHeightOfExpr(heights map[string]int, expr Expr) int {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		height, ok := heights[expr.Name]
+		if !ok || height == InfiniteHeight {
+			return InfiniteHeight
+		}
+		return height + 1
+	case ExprConcat:
+		result := 0
+		for i := range expr.Elements {
+			height := MinHeightOfExpr(heights, expr.Elements[i])
+			if height > result {
+				result = height
+			}
+		}
+		return result
+	case ExprAlternation:
+		result := InfiniteHeight
+		for i := range expr.Variants {
+			height := MinHeightOfExpr(heights, expr.Variants[i])
+			if height < result {
+				result = height
+			}
+		}
+		return result
+	case ExprRepetition:
+		if expr.Lower == 0 {
+			return 0
+		}
+		return MinHeightOfExpr(heights, expr.Body)
+	case ExprException:
+		return MinHeightOfExpr(heights, expr.Body)
+	default:
+		return 0
+	}
+}
+
+type DistributionKind int
+
+const (
+	DistributionUniform DistributionKind = iota
+	// The amounts are picked by the weights in Counts
+	DistributionCounts
+	// The amounts above the lower bound are geometrically distributed with the mean of Param
+	DistributionGeometric
+	// The amounts above the lower bound are Poisson distributed with the mean of Param
+	DistributionPoisson
+	// One of the bounds is picked with the probability of Param, otherwise the amounts are uniform
+	DistributionBounds
+)
+
+var DistributionKindName = map[DistributionKind]string{
+	DistributionUniform: "uniform",
+	DistributionCounts: "counts",
+	DistributionGeometric: "geometric",
+	DistributionPoisson: "poisson",
+	DistributionBounds: "bounds",
+}
+
+// How the amount of repetitions is picked between the bounds of ExprRepetition
+type Distribution struct {
+	Kind DistributionKind
+	// The relative weights of the amounts for DistributionCounts. The amounts outside
+	// of the bounds are ignored.
+	Counts map[uint]uint
+	Param float64
+}
+
+func (dist *Distribution) String() string {
+	switch dist.Kind {
+	case DistributionGeometric, DistributionPoisson, DistributionBounds:
+		return fmt.Sprintf("%s:%g", DistributionKindName[dist.Kind], dist.Param)
+	}
+	return DistributionKindName[dist.Kind]
+}
+
+// Parses the distributions like `uniform`, `geometric:3`, `poisson:5` or `bounds:0.5`
+func ParseDistribution(text string) (dist *Distribution, err error) {
+	name, param, hasParam := strings.Cut(text, ":")
+	dist = &Distribution{}
+	found := false
+	for kind, kindName := range DistributionKindName {
+		if kindName == name && kind != DistributionCounts {
+			dist.Kind = kind
+			found = true
+		}
+	}
+	if !found {
+		err = fmt.Errorf("Unknown distribution %s. Expected uniform, geometric:MEAN, poisson:MEAN or bounds:PROBABILITY", name)
+		return
+	}
+	if dist.Kind == DistributionUniform {
+		if hasParam {
+			err = fmt.Errorf("The uniform distribution does not have parameters")
+		}
+		return
+	}
+	if !hasParam {
+		err = fmt.Errorf("The %s distribution requires a parameter like %s:3", name, name)
+		return
+	}
+	dist.Param, err = strconv.ParseFloat(param, 64)
+	if err != nil {
+		err = fmt.Errorf("Invalid parameter of the %s distribution: %s", name, param)
+		return
+	}
+	if dist.Param < 0 || math.IsNaN(dist.Param) || math.IsInf(dist.Param, 0) || (dist.Kind == DistributionBounds && dist.Param > 1) {
+		err = fmt.Errorf("The parameter of the %s distribution is out of range: %s", name, param)
+	}
+	return
+}
+
+// The probability of picking lower + k repetitions before the upper bound is applied
+func (dist *Distribution) Probability(lower uint, upper uint, k uint) float64 {
+	n := float64(upper - lower + 1)
+	switch dist.Kind {
+	case DistributionCounts:
+		return float64(dist.Counts[lower + k])
+	case DistributionGeometric:
+		mean := dist.Param
+		if mean <= 0 {
+			if k == 0 {
+				return 1
+			}
+			return 0
+		}
+		p := 1/(1 + mean)
+		return p*math.Pow(1 - p, float64(k))
+	case DistributionPoisson:
+		mean := dist.Param
+		if mean <= 0 {
+			if k == 0 {
+				return 1
+			}
+			return 0
+		}
+		logGamma, _ := math.Lgamma(float64(k) + 1)
+		return math.Exp(-mean + float64(k)*math.Log(mean) - logGamma)
+	case DistributionBounds:
+		probability := (1 - dist.Param)/n
+		if k == 0 {
+			probability += dist.Param/2
+		}
+		if lower + k == upper {
+			probability += dist.Param/2
+		}
+		return probability
+	}
+	return 1/n
+}
+
+// The weight of every amount of repetitions between the bounds. The amounts are picked uniformly
+// if none of them has a weight.
+func (dist *Distribution) Weights(lower uint, upper uint) (weights []float64) {
+	total := 0.0
+	for n := lower; n <= upper; n += 1 {
+		weight := 1.0
+		if dist != nil {
+			weight = dist.Probability(lower, upper, n - lower)
+		}
+		weights = append(weights, weight)
+		total += weight
+	}
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	return
+}
+
+// Samples the amount of repetitions above the lower bound for the unbounded distributions
+func (dist *Distribution) SampleExtra(rnd *rand.Rand) uint {
+	mean := dist.Param
+	if mean <= 0 {
+		return 0
+	}
+	switch dist.Kind {
+	case DistributionGeometric:
+		p := 1/(1 + mean)
+		return uint(math.Floor(math.Log(1 - rnd.Float64())/math.Log(1 - p)))
+	case DistributionPoisson:
+		if mean > 30 {
+			return uint(math.Max(0, math.Round(mean + math.Sqrt(mean)*rnd.NormFloat64())))
+		}
+		// Knuth's algorithm
+		limit := math.Exp(-mean)
+		k := uint(0)
+		for p := rnd.Float64(); p > limit; p *= rnd.Float64() {
+			k += 1
+		}
+		return k
+	}
+	panic("unreachable")
+}
+
+func (dist *Distribution) Pick(rnd *rand.Rand, lower uint, upper uint) uint {
+	if dist == nil || dist.Kind == DistributionUniform {
+		return lower + uint(rnd.Int63n(int64(upper - lower + 1)))
+	}
+	switch dist.Kind {
+	case DistributionGeometric, DistributionPoisson:
+		// The amounts above the upper bound are rejected, so the distribution is truncated
+		// rather than piled up at the bound
+		for attempt := 0; attempt < 100; attempt += 1 {
+			if extra := dist.SampleExtra(rnd); extra <= upper - lower {
+				return lower + extra
+			}
+		}
+		return upper
+	case DistributionBounds:
+		if rnd.Float64() < dist.Param {
+			if rnd.Int31n(2) == 0 {
+				return lower
+			}
+			return upper
+		}
+		return lower + uint(rnd.Int63n(int64(upper - lower + 1)))
+	}
+	weights := dist.Weights(lower, upper)
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	x := rnd.Float64()*total
+	for i, weight := range weights {
+		x -= weight
+		if x < 0 {
+			return lower + uint(i)
+		}
+	}
+	return upper
+}
+
+// The expected amount of repetitions
+func (dist *Distribution) Mean(lower uint, upper uint) float64 {
+	if dist == nil || dist.Kind == DistributionUniform || dist.Kind == DistributionBounds {
+		return float64(lower + upper)/2
+	}
+	weights := dist.Weights(lower, upper)
+	total := 0.0
+	sum := 0.0
+	for i, weight := range weights {
+		total += weight
+		sum += weight*float64(lower + uint(i))
+	}
+	return sum/total
+}
+
+const DefaultMaxDepth = 32
+
+// The depth alone does not bound the size, `c = "z" / c c c` grows exponentially with it
+const DefaultMaxSize = 4096
+
+// Generates random messages matching the rules of the Grammar
+type Generator struct {
+	Grammar *Grammar
+	Rand *rand.Rand
+	// The seeds of the messages are derived from it, see MessageSeed
+	Seed int64
+	// How many messages were generated by Next so far
+	Count int
+	// How deep the symbols may be nested before the generator picks only the alternatives
+	// with the minimal derivation height. 0 means no limit.
+	MaxDepth int
+	// How many characters a message may have before the generator picks only the alternatives
+	// with the minimal derivation height. 0 means no limit. The message may exceed it by
+	// the shortest way to finish the symbols that are already started.
+	MaxSize int
+	// Every value of the message is a single octet, see Octets. The literals that don't
+	// fit in a byte are reported as errors.
+	Bytes bool
+	// Applies a mutation operator while generating, see NegativeGenerator. nil means the messages match the grammar.
+	Negative *NegativeMutation
+	// Computed from the Grammar on the first Generate
+	Heights map[string]int
+	Nullable map[string]bool
+	// The rule that is being generated
+	Rule string
+	// The amount of characters generated for the current message so far
+	Size int
+}
+
+func NewGenerator(grammar *Grammar, seed int64) *Generator {
+	return &Generator{
+		Grammar: grammar,
+		Rand: rand.New(rand.NewSource(seed)),
+		Seed: seed,
+		MaxDepth: DefaultMaxDepth,
+		MaxSize: DefaultMaxSize,
+	}
+}
+
+// The seed of the index-th message of the run started with the seed. Mixed with SplitMix64,
+// so the seeds of the neighbouring messages and runs don't correlate.
+func MessageSeed(seed int64, index int) int64 {
+	x := uint64(seed) + uint64(index + 1)*0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30))*0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27))*0x94D049BB133111EB
+	return int64(x ^ (x >> 31))
+}
+
+// Generates the next message of the run. Every message has its own seed, so it can be
+// regenerated on its own with GenerateWithSeed.
+func (gen *Generator) Next(entry string) (message []rune, seed int64, err error) {
+	seed = MessageSeed(gen.Seed, gen.Count)
+	gen.Count += 1
+	message, err = gen.GenerateWithSeed(entry, seed)
+	return
+}
+
+// Generates exactly the message that Next reported with the seed
+func (gen *Generator) GenerateWithSeed(entry string, seed int64) (message []rune, err error) {
+	gen.Rand = rand.New(rand.NewSource(seed))
+	return gen.Generate(entry)
+}
+
+func (gen *Generator) SizeExhausted() bool {
+	return gen.MaxSize > 0 && gen.Size >= gen.MaxSize
+}
+
+// Whether an expression of the given height can still be finished within the budget
+func (gen *Generator) Fits(depth int, height int) bool {
+	if height == InfiniteHeight || gen.SizeExhausted() {
+		return false
+	}
+	return gen.MaxDepth <= 0 || depth + height <= gen.MaxDepth
+}
+
+// Picks the indices of the variants that can be finished within the budget. If there are none
+// of them only the variants with the minimal derivation height are left, so the generation
+// always moves towards the end.
+func (gen *Generator) FinishingVariants(variants []Expr, depth int) (result []int) {
+	if gen.MaxDepth <= 0 && gen.MaxSize <= 0 {
+		for i := range variants {
+			result = append(result, i)
+		}
+		return
+	}
+	shortest := []int{}
+	minHeight := InfiniteHeight
+	for i, variant := range variants {
+		height := MinHeightOfExpr(gen.Heights, variant)
+		if gen.Fits(depth, height) {
+			result = append(result, i)
+		}
+		if height < minHeight {
+			minHeight = height
+			shortest = []int{}
+		}
+		if height == minHeight && height != InfiniteHeight {
+			shortest = append(shortest, i)
+		}
+	}
+	if len(result) == 0 || gen.SizeExhausted() {
+		result = shortest
+	}
+	return
+}
+
+// Picks one of the candidate variants of the alternation according to their weights. The candidates
+// are picked uniformly if the alternation has no weights or all of the candidates weigh 0.
+func (gen *Generator) PickVariant(alt ExprAlternation, candidates []int) int {
+	if alt.Weights != nil {
+		total := int64(0)
+		for _, i := range candidates {
+			total += int64(alt.Weights[i])
+		}
+		if total > 0 {
+			n := gen.Rand.Int63n(total)
+			for _, i := range candidates {
+				n -= int64(alt.Weights[i])
+				if n < 0 {
+					return i
+				}
+			}
+		}
+	}
+	return candidates[gen.Rand.Int31n(int32(len(candidates)))]
+}
+
+// Generates a random message starting from the entry symbol
+func (gen *Generator) Generate(entry string) (message []rune, err error) {
+	rule, ok := gen.Grammar.Rules[entry]
+	if !ok {
+		err = &DiagErr{
+			Code: CodeUndefinedSymbol,
+			Err: fmt.Errorf("Symbol %s is not defined", entry),
+			Help: SymbolHelp(gen.Grammar.Rules, entry),
+		}
+		return
+	}
+	if gen.Heights == nil {
+		gen.Heights = MinHeights(gen.Grammar.Rules)
+	}
+	if gen.Nullable == nil && gen.Negative != nil {
+		gen.Nullable = NullableRules(gen.Grammar.Rules)
+	}
+	gen.Size = 0
+	gen.Rule = entry
+	message, err = gen.GenerateRandomMessage(rule.Body, 0)
+	err = WithSources(err, gen.Grammar.Sources)
+	return
+}
+
+// Converts the message generated in the Bytes mode to the raw octets
+func Octets(message []rune) (octets []byte) {
+	octets = make([]byte, len(message))
+	for i, x := range message {
+		octets[i] = byte(x)
+	}
+	return
+}
+
+func ExpectByte(loc Loc, x rune) (err error) {
+	if x > 0xFF {
+		err = &DiagErr{
+			Loc: loc,
+			Code: CodeByteValue,
+			Err: fmt.Errorf("Value %%x%X does not fit in a byte", x),
+		}
+	}
+	return
+}
+
+func (gen *Generator) ExpectByte(loc Loc, x rune) (err error) {
+	if gen.Bytes {
+		err = ExpectByte(loc, x)
+	}
+	return
+}
+
+// The depth is the amount of symbols expanded on the way to the expr
+func (gen *Generator) GenerateRandomMessage(expr Expr, depth int) (message []rune, err error) {
+	grammar := gen.Grammar.Rules
+	switch expr := expr.(type) {
+	case ExprString:
+		if len(expr.Text) > 0 && gen.NegativeSite(OpString, expr.Loc) {
+			message = gen.NearMiss(expr.Text, expr.CaseInsensitive)
+			gen.Size += len(message)
+			return
+		}
+		gen.Size += len(expr.Text)
+		for _, x := range expr.Text {
+			if err = gen.ExpectByte(expr.Loc, x); err != nil {
+				return
+			}
+		}
+		if !expr.CaseInsensitive {
+			message = expr.Text
+			return
+		}
+		for _, x := range expr.Text {
+			if gen.Rand.Int31n(2) == 0 {
+				message = append(message, ToUpperASCII(x))
+			} else {
+				message = append(message, ToLowerASCII(x))
+			}
+		}
+	case ExprSymbol:
+		nextExpr, ok := grammar[expr.Name]
+		if !ok {
+			err = &DiagErr{
+				Loc: expr.Loc,
+				Len: len(expr.Name),
+				Code: CodeUndefinedSymbol,
+				Err: fmt.Errorf("Symbol <%s> is not defined", expr.Name),
+				Help: SymbolHelp(grammar, expr.Name),
+			}
+			return
+		}
+		if gen.Heights[expr.Name] == InfiniteHeight && (gen.SizeExhausted() || (gen.MaxDepth > 0 && depth >= gen.MaxDepth)) {
+			err = &DiagErr{
+				Loc: expr.Loc,
+				Len: len(expr.Name),
+				Err: fmt.Errorf("Symbol %s can not produce a finite message", expr.Name),
+			}
+			return
+		}
+		rule := gen.Rule
+		gen.Rule = expr.Name
+		message, err = gen.GenerateRandomMessage(nextExpr.Body, depth + 1)
+		gen.Rule = rule
+	case ExprConcat:
+		for i := range expr.Elements {
+			if gen.Negative != nil && !IsNullableExpr(gen.Nullable, expr.Elements[i]) && gen.NegativeSite(OpDrop, expr.Elements[i].GetLoc()) {
+				continue
+			}
+			var element []rune
+			element, err = gen.GenerateRandomMessage(expr.Elements[i], depth)
+			if err != nil {
+				return
+			}
+			message = append(message, element...)
+		}
+	case ExprAlternation:
+		if expr.Class != nil && expr.Weights == nil {
+			message, err = gen.GenerateRandomMessage(*expr.Class, depth)
+			return
+		}
+		candidates := gen.FinishingVariants(expr.Variants, depth)
+		if len(candidates) == 0 {
+			err = &DiagErr{
+				Loc: expr.Loc,
+				Err: fmt.Errorf("None of the alternatives can produce a finite message"),
+			}
+			return
+		}
+		message, err = gen.GenerateRandomMessage(expr.Variants[gen.PickVariant(expr, candidates)], depth)
+	case ExprRepetition:
+		if expr.Lower > expr.Upper {
+			err = &DiagErr{
+				Loc: expr.Loc,
+				Err: fmt.Errorf("Upper bound of the repetition is lower than the lower one."),
+			}
+			return
+		}
+		n := int(expr.Distribution.Pick(gen.Rand, expr.Lower, expr.Upper))
+		exceed := false
+		if !expr.Unbounded && gen.NegativeSite(OpRepeatMore, expr.Loc) {
+			n = int(expr.Upper) + 1
+			exceed = true
+		} else if expr.Lower > 0 && gen.NegativeSite(OpRepeatLess, expr.Loc) {
+			n = int(expr.Lower) - 1
+		}
+		for i := 0; i < n; i += 1 {
+			if !exceed && uint(i) >= expr.Lower && (gen.MaxDepth > 0 || gen.MaxSize > 0) && !gen.Fits(depth, MinHeightOfExpr(gen.Heights, expr.Body)) {
+				break
+			}
+			var childMessage []rune
+			childMessage, err = gen.GenerateRandomMessage(expr.Body, depth)
+			if err != nil {
+				return
+			}
+			message = append(message, childMessage...)
+		}
+	case ExprRange:
+		if expr.Lower > expr.Upper {
+			err = &DiagErr{
+				Loc: expr.Loc,
+				Err: fmt.Errorf("Upper bound of the range is lower than the lower one."),
+			}
+			return
+		}
+		if err = gen.ExpectByte(expr.Loc, expr.Upper); err != nil {
+			return
+		}
+		if class := NewCharClass(expr.Loc, []ExprRange{expr}); gen.Negative != nil && gen.HasOutsideChar(class) && gen.NegativeSite(OpRange, expr.Loc) {
+			x, _ := gen.OutsideChar(class)
+			gen.Size += 1
+			message = append(message, x)
+			return
+		}
+
+		gen.Size += 1
+		message = append(message, expr.Lower + gen.Rand.Int31n(expr.Upper - expr.Lower + 1))
+	case ExprCharClass:
+		size := expr.Size()
+		if size == 0 {
+			err = &DiagErr{
+				Loc: expr.Loc,
+				Err: fmt.Errorf("Character class does not contain any characters"),
+			}
+			return
+		}
+		if err = gen.ExpectByte(expr.Loc, expr.Ranges[len(expr.Ranges) - 1].Upper); err != nil {
+			return
+		}
+		if gen.Negative != nil && gen.HasOutsideChar(expr) && gen.NegativeSite(OpRange, expr.Loc) {
+			x, _ := gen.OutsideChar(expr)
+			gen.Size += 1
+			message = append(message, x)
+			return
+		}
+		gen.Size += 1
+		message = append(message, expr.Nth(gen.Rand.Int63n(size)))
+	case ExprException:
+		err = &DiagErr{
+			Loc: expr.Loc,
+			Code: CodeUnsupportedException,
+			Err: fmt.Errorf("Exception can not be generated"),
+		}
+	default:
+		panic("unreachable")
+	}
+	return
+}
+
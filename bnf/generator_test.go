@@ -0,0 +1,121 @@
+package bnf
+
+import (
+	"math"
+	"testing"
+)
+
+// Every generated message has to be accepted by the recognizer of the same entry
+func TestGeneratorMatchesRecognizer(t *testing.T) {
+	tests := []struct {
+		grammar string
+		entry string
+	}{
+		{"a = 1*( ALPHA / DIGIT ) [ \"=\" 2*4HEXDIG ] CRLF\n", "a"},
+		{"a = @9 \"x\" / @1 \"(\" a \")\"\n", "a"},
+		{"a = %i\"select\" *( SP b )\nb = %x21-7E / %xE9\n", "a"},
+		{"a = *b\nb = \"x\" / c\nc = \"(\" a \")\"\n", "a"},
+	}
+	for _, test := range tests {
+		grammar := MustParse(t, test.grammar, "test.abnf")
+		rec, err := NewRecognizer(grammar, test.entry)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		gen := NewGenerator(grammar, 1)
+		gen.MaxSize = 200
+		for i := 0; i < 100; i += 1 {
+			message, seed, err := gen.Next(test.entry)
+			if err != nil {
+				t.Fatalf("%q: %s", test.grammar, err)
+			}
+			if !rec.Accepted(rec.Parse(message)) {
+				t.Fatalf("%q: the message %q of the seed %d does not match", test.grammar, string(message), seed)
+			}
+			again, err := gen.GenerateWithSeed(test.entry, seed)
+			if err != nil || string(again) != string(message) {
+				t.Fatalf("%q: the seed %d regenerated %q instead of %q", test.grammar, seed, string(again), string(message))
+			}
+		}
+	}
+}
+
+func TestGeneratorCharAlternation(t *testing.T) {
+	grammar := MustParse(t, "a = \"x\" / %x30-39\n", "test.abnf")
+	gen := NewGenerator(grammar, 1)
+	x := 0
+	for i := 0; i < 1100; i += 1 {
+		message, _, err := gen.Next("a")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if string(message) == "x" {
+			x += 1
+		}
+	}
+	// Every one of the 11 characters is equally likely rather than every alternative
+	if x < 50 || x > 150 {
+		t.Fatalf("expected about 100 of x, got %d", x)
+	}
+}
+
+func TestDistributionMean(t *testing.T) {
+	tests := []struct {
+		distribution string
+		lower uint
+		upper uint
+		mean float64
+	}{
+		{"uniform", 2, 6, 4},
+		{"geometric:3", 0, 100, 3},
+		{"geometric:3", 10, 100, 13},
+		{"poisson:3", 10, 100, 13},
+		{"poisson:0", 10, 100, 10},
+		{"bounds:1", 0, 10, 5},
+	}
+	for _, test := range tests {
+		dist, err := ParseDistribution(test.distribution)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if mean := dist.Mean(test.lower, test.upper); math.Abs(mean - test.mean) > 0.01 {
+			t.Errorf("%s between %d and %d: expected the mean %g, got %g", test.distribution, test.lower, test.upper, test.mean, mean)
+		}
+
+		grammar, err := ParseString("a = *\"x\"\n", "test.abnf", Options{Repetitions: dist})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		rule := grammar.Rules["a"]
+		repetition := rule.Body.(ExprRepetition)
+		repetition.Lower, repetition.Upper = test.lower, test.upper
+		rule.Body = repetition
+		grammar.Rules["a"] = rule
+		gen := NewGenerator(grammar, 1)
+		total := 0
+		n := 4000
+		for i := 0; i < n; i += 1 {
+			message, _, err := gen.Next("a")
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			total += len(message)
+		}
+		if mean := float64(total)/float64(n); math.Abs(mean - test.mean) > 0.25 {
+			t.Errorf("%s between %d and %d: expected the average %g, got %g", test.distribution, test.lower, test.upper, test.mean, mean)
+		}
+	}
+}
+
+func TestParseDistribution(t *testing.T) {
+	for _, text := range []string{"uniform", "geometric:3", "poisson:0.5", "bounds:0.25"} {
+		if dist, err := ParseDistribution(text); err != nil || dist.String() != text {
+			t.Errorf("%s: expected to parse back, got %v %v", text, dist, err)
+		}
+	}
+	for _, text := range []string{"normal", "geometric", "uniform:1", "poisson:-1", "bounds:2", "geometric:NaN"} {
+		if _, err := ParseDistribution(text); err == nil {
+			t.Errorf("%s: expected an error", text)
+		}
+	}
+}
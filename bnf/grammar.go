@@ -0,0 +1,1091 @@
+package bnf
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The exceptions that can not be lowered into ExprCharClass are kept in the expr and reported in errs
+func LowerExceptionsInExpr(grammar map[string]Rule, expr Expr) (result Expr, errs Errors) {
+	switch expr := expr.(type) {
+	case ExprException:
+		result = expr
+		class, ok := CharClassOfExpr(grammar, expr, map[string]bool{})
+		if !ok {
+			errs.Add(&DiagErr{
+				Loc: expr.Loc,
+				Code: CodeUnsupportedException,
+				Err: fmt.Errorf("Exception is only supported between sets of single characters"),
+			})
+			return
+		}
+		if class.Size() == 0 {
+			errs.Add(&DiagErr{
+				Loc: expr.Loc,
+				Code: CodeUnsupportedException,
+				Err: fmt.Errorf("Exception excludes every character"),
+			})
+			return
+		}
+		result = class
+	case ExprAlternation:
+		variants := []Expr{}
+		for i := range expr.Variants {
+			variant, variantErrs := LowerExceptionsInExpr(grammar, expr.Variants[i])
+			errs = append(errs, variantErrs...)
+			variants = append(variants, variant)
+		}
+		expr.Variants = variants
+		result = WithCharClass(expr)
+	case ExprConcat:
+		elements := []Expr{}
+		for i := range expr.Elements {
+			element, elementErrs := LowerExceptionsInExpr(grammar, expr.Elements[i])
+			errs = append(errs, elementErrs...)
+			elements = append(elements, element)
+		}
+		expr.Elements = elements
+		result = expr
+	case ExprRepetition:
+		expr.Body, errs = LowerExceptionsInExpr(grammar, expr.Body)
+		result = expr
+	default:
+		result = expr
+	}
+	return
+}
+
+// Exceptions may refer to the symbols defined anywhere in the grammar, so they are lowered
+// into ExprCharClass only after the whole grammar is parsed. The exceptions that can not be
+// lowered are not errors yet, because the rules that have them may never be reached from
+// the entry, see Grammar.VerifyExceptions.
+func LowerExceptions(grammar map[string]Rule) (unsupported map[string]Errors) {
+	unsupported = map[string]Errors{}
+	lowered := map[string]Rule{}
+	for name, rule := range grammar {
+		body, errs := LowerExceptionsInExpr(grammar, rule.Body)
+		if len(errs) > 0 {
+			unsupported[name] = errs
+		}
+		rule.Body = body
+		lowered[name] = rule
+	}
+	for name, rule := range lowered {
+		grammar[name] = rule
+	}
+	return
+}
+
+func VerifyThatAllSymbolsDefinedInExpr(grammar map[string]Rule, expr Expr) (errs Errors) {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		if _, exists := grammar[expr.Name]; !exists {
+			errs.Add(&DiagErr{
+				Loc: expr.Loc,
+				Len: len(expr.Name),
+				Code: CodeUndefinedSymbol,
+				Err: fmt.Errorf("Symbol %s is not defined", expr.Name),
+				Help: SymbolHelp(grammar, expr.Name),
+			})
+		}
+		return
+
+	case ExprAlternation:
+		for i := range expr.Variants {
+			errs = append(errs, VerifyThatAllSymbolsDefinedInExpr(grammar, expr.Variants[i])...)
+		}
+		return
+
+	case ExprConcat:
+		for i := range expr.Elements {
+			errs = append(errs, VerifyThatAllSymbolsDefinedInExpr(grammar, expr.Elements[i])...)
+		}
+		return
+
+	case ExprRepetition:
+		errs = VerifyThatAllSymbolsDefinedInExpr(grammar, expr.Body)
+		return
+
+	case ExprException:
+		errs = VerifyThatAllSymbolsDefinedInExpr(grammar, expr.Body)
+		errs = append(errs, VerifyThatAllSymbolsDefinedInExpr(grammar, expr.Except)...)
+		return
+
+	case ExprString:
+		return
+
+	case ExprRange:
+		return
+
+	case ExprCharClass:
+		return
+
+	default: panic("unreachable")
+	}
+}
+
+func VerifyThatAllSymbolsDefined(grammar map[string]Rule) (errs Errors) {
+	names := []string{}
+	for name := range grammar {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, VerifyThatAllSymbolsDefinedInExpr(grammar, grammar[name].Body)...)
+	}
+	return
+}
+
+// Finds the first symbol in the expr that is defined but can not produce a finite message
+func FirstNonProductiveSymbol(grammar map[string]Rule, heights map[string]int, expr Expr) (name string, ok bool) {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		if _, exists := grammar[expr.Name]; exists && heights[expr.Name] == InfiniteHeight {
+			return expr.Name, true
+		}
+	case ExprConcat:
+		for i := range expr.Elements {
+			if name, ok = FirstNonProductiveSymbol(grammar, heights, expr.Elements[i]); ok {
+				return
+			}
+		}
+	case ExprAlternation:
+		for i := range expr.Variants {
+			if name, ok = FirstNonProductiveSymbol(grammar, heights, expr.Variants[i]); ok {
+				return
+			}
+		}
+	case ExprRepetition:
+		if expr.Lower > 0 {
+			return FirstNonProductiveSymbol(grammar, heights, expr.Body)
+		}
+	}
+	return
+}
+
+// Points to the definitions of the symbols of the cycle other than the reported one
+func CycleNotes(grammar map[string]Rule, reported string, cycle []string) (notes []DiagNote) {
+	for _, name := range cycle {
+		if name == reported {
+			continue
+		}
+		notes = append(notes, DiagNote{
+			Loc: grammar[name].Head.Loc,
+			Message: fmt.Sprintf("%s is defined here", name),
+		})
+	}
+	return
+}
+
+func FormatCycle(cycle []string) string {
+	return strings.Join(append(append([]string{}, cycle...), cycle[0]), " -> ")
+}
+
+// Finds the rules that can never derive a finite string of terminals, like `a = "x" a`.
+// The rules that are non-productive only because of the undefined symbols are left to
+// VerifyThatAllSymbolsDefined.
+func VerifyThatAllSymbolsProductive(grammar map[string]Rule) (errs Errors) {
+	heights := MinHeights(grammar)
+	names := []string{}
+	for name := range grammar {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if heights[name] != InfiniteHeight {
+			continue
+		}
+		path := []string{}
+		index := map[string]int{}
+		for current, ok := name, true; ok; current, ok = FirstNonProductiveSymbol(grammar, heights, grammar[current].Body) {
+			if i, seen := index[current]; seen {
+				cycle := path[i:]
+				errs.Add(&DiagErr{
+					Loc: grammar[name].Head.Loc,
+					Code: CodeNonProductive,
+					Err: fmt.Errorf("%s can never produce a finite message, because of the cycle %s", name, FormatCycle(cycle)),
+					Notes: CycleNotes(grammar, name, cycle),
+				})
+				break
+			}
+			index[current] = len(path)
+			path = append(path, current)
+		}
+	}
+	return
+}
+
+// Accumulates how many times every symbol is expanded on average by one expansion of the expr
+// when the alternatives and the amounts of repetitions are picked by their weights
+func ExpectedSymbolCounts(expr Expr, factor float64, counts map[string]float64) {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		counts[expr.Name] += factor
+	case ExprConcat:
+		for i := range expr.Elements {
+			ExpectedSymbolCounts(expr.Elements[i], factor, counts)
+		}
+	case ExprAlternation:
+		total := uint(0)
+		for _, weight := range expr.Weights {
+			total += weight
+		}
+		for i := range expr.Variants {
+			probability := 1/float64(len(expr.Variants))
+			if total > 0 {
+				probability = float64(expr.Weights[i])/float64(total)
+			}
+			ExpectedSymbolCounts(expr.Variants[i], factor*probability, counts)
+		}
+	case ExprRepetition:
+		ExpectedSymbolCounts(expr.Body, factor*expr.Distribution.Mean(expr.Lower, expr.Upper), counts)
+	case ExprException:
+		ExpectedSymbolCounts(expr.Body, factor, counts)
+	}
+}
+
+// Tarjan's algorithm. The components come out in the reverse topological order.
+func StronglyConnectedComponents(names []string, edges map[string]map[string]float64) (components [][]string) {
+	index := map[string]int{}
+	lowlink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	var connect func(name string)
+	connect = func(name string) {
+		index[name] = len(index)
+		lowlink[name] = index[name]
+		stack = append(stack, name)
+		onStack[name] = true
+		targets := []string{}
+		for target := range edges[name] {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+		for _, target := range targets {
+			if _, visited := index[target]; !visited {
+				connect(target)
+				if lowlink[target] < lowlink[name] {
+					lowlink[name] = lowlink[target]
+				}
+			} else if onStack[target] && index[target] < lowlink[name] {
+				lowlink[name] = index[target]
+			}
+		}
+		if lowlink[name] == index[name] {
+			component := []string{}
+			for {
+				top := stack[len(stack) - 1]
+				stack = stack[:len(stack) - 1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == name {
+					break
+				}
+			}
+			sort.Strings(component)
+			components = append(components, component)
+		}
+	}
+	for _, name := range names {
+		if _, visited := index[name]; !visited {
+			connect(name)
+		}
+	}
+	return
+}
+
+// Whether the spectral radius of the matrix of the expected symbol counts within the component
+// is less than 1, i.e. the expected size of the expansion is finite. That is the case exactly when
+// (I - M)x = 1 has a positive solution.
+func ExpectedSizeIsFinite(component []string, edges map[string]map[string]float64) bool {
+	const epsilon = 1e-9
+	n := len(component)
+	matrix := make([][]float64, n)
+	for i := range component {
+		matrix[i] = make([]float64, n + 1)
+		for j := range component {
+			matrix[i][j] = -edges[component[i]][component[j]]
+		}
+		matrix[i][i] += 1
+		matrix[i][n] = 1
+	}
+	for col := 0; col < n; col += 1 {
+		pivot := col
+		for row := col + 1; row < n; row += 1 {
+			if math.Abs(matrix[row][col]) > math.Abs(matrix[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(matrix[pivot][col]) < epsilon {
+			return false
+		}
+		matrix[col], matrix[pivot] = matrix[pivot], matrix[col]
+		for row := 0; row < n; row += 1 {
+			if row == col {
+				continue
+			}
+			factor := matrix[row][col]/matrix[col][col]
+			for k := col; k <= n; k += 1 {
+				matrix[row][k] -= factor*matrix[col][k]
+			}
+		}
+	}
+	for i := 0; i < n; i += 1 {
+		if matrix[i][n]/matrix[i][i] <= epsilon {
+			return false
+		}
+	}
+	return true
+}
+
+// The shortest cycle from the start back to itself going only through the symbols of the component
+func ShortestCycle(start string, component []string, edges map[string]map[string]float64) []string {
+	inComponent := map[string]bool{}
+	for _, name := range component {
+		inComponent[name] = true
+	}
+	parent := map[string]string{}
+	queue := []string{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		targets := []string{}
+		for target := range edges[current] {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+		for _, target := range targets {
+			if target == start {
+				cycle := []string{}
+				for name := current; name != start; name = parent[name] {
+					cycle = append([]string{name}, cycle...)
+				}
+				return append([]string{start}, cycle...)
+			}
+			if _, seen := parent[target]; !seen && inComponent[target] {
+				parent[target] = current
+				queue = append(queue, target)
+			}
+		}
+	}
+	return []string{start}
+}
+
+// Warns about the recursive rules that expand on average into infinitely large messages when
+// the alternatives are picked by their weights, like `a = "x" / a a`. The generation from such rules
+// relies on -max-depth and -max-size to finish.
+func VerifyThatExpectedSizesAreFinite(grammar map[string]Rule) (errs Errors) {
+	heights := MinHeights(grammar)
+	names := []string{}
+	edges := map[string]map[string]float64{}
+	for name, rule := range grammar {
+		if heights[name] == InfiniteHeight {
+			continue
+		}
+		names = append(names, name)
+		counts := map[string]float64{}
+		ExpectedSymbolCounts(rule.Body, 1, counts)
+		edges[name] = map[string]float64{}
+		for target, count := range counts {
+			if height, ok := heights[target]; ok && height != InfiniteHeight && count > 0 {
+				edges[name][target] = count
+			}
+		}
+	}
+	sort.Strings(names)
+
+	components := StronglyConnectedComponents(names, edges)
+	sort.Slice(components, func(i, j int) bool {
+		return components[i][0] < components[j][0]
+	})
+	for _, component := range components {
+		if len(component) == 1 && edges[component[0]][component[0]] == 0 {
+			continue
+		}
+		if ExpectedSizeIsFinite(component, edges) {
+			continue
+		}
+		name := component[0]
+		cycle := ShortestCycle(name, component, edges)
+		errs.Add(&DiagErr{
+			Loc: grammar[name].Head.Loc,
+			Severity: SeverityWarning,
+			Code: CodeInfiniteExpectedSize,
+			Err: fmt.Errorf("%s expands into infinitely large messages on average, because of the cycle %s", name, FormatCycle(cycle)),
+			Notes: CycleNotes(grammar, name, cycle),
+		})
+	}
+	return
+}
+
+func WalkSymbolsInExpr(grammar map[string]Rule, expr Expr, visited map[string]bool) (err error) {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		if !visited[expr.Name] {
+			visited[expr.Name] = true
+			rule, exists := grammar[expr.Name]
+			if !exists {
+				err = &DiagErr{
+					Loc: expr.Loc,
+					Code: CodeUndefinedSymbol,
+					Err: fmt.Errorf("Symbol <%s> is not defined", expr.Name),
+				}
+				return
+			}
+			err = WalkSymbolsInExpr(grammar, rule.Body, visited)
+			if err != nil {
+				return
+			}
+		}
+		return
+	case ExprString:
+		return
+	case ExprAlternation:
+		for i := range expr.Variants {
+			err = WalkSymbolsInExpr(grammar, expr.Variants[i], visited)
+			if err != nil {
+				return
+			}
+		}
+		return
+	case ExprConcat:
+		for i := range expr.Elements {
+			err = WalkSymbolsInExpr(grammar, expr.Elements[i], visited)
+			if err != nil {
+				return
+			}
+		}
+		return
+	case ExprRepetition:
+		return WalkSymbolsInExpr(grammar, expr.Body, visited)
+	case ExprException:
+		err = WalkSymbolsInExpr(grammar, expr.Body, visited)
+		if err != nil {
+			return
+		}
+		return WalkSymbolsInExpr(grammar, expr.Except, visited)
+	case ExprRange:
+		return
+	case ExprCharClass:
+		return
+	}
+	panic(fmt.Sprintf("unreachable: %T", expr))
+}
+
+type Grammar struct {
+	Rules map[string]Rule
+	// Names of the RFC 5234 core rules that were not shadowed by the rules of the grammar
+	CoreNames []string
+	// The contents of the files of the grammar for the diagnostics
+	Sources Sources
+	// The errors of the exceptions that could not be lowered into ExprCharClass by the name
+	// of the rule. They are reported only for the rules reachable from the entry.
+	Exceptions map[string]Errors
+}
+
+type Options struct {
+	// nil picks the syntax by the extension of the file, see SyntaxOfFile
+	Syntax *Syntax
+	// Do not provide the RFC 5234 core rules
+	NoCore bool
+	// Provide the RFC 5234 core rules even if the syntax does not, see Syntax.Core
+	Core bool
+	// The upper bound of the repetitions that don't specify it, like `*a`.
+	// nil means MaxUnspecifiedUpperRepetitionBound, 0 leaves only the lower bounds.
+	MaxRepetitions *uint
+	// The distribution of the amounts of all of the repetitions. nil means uniform.
+	Repetitions *Distribution
+}
+
+// Parses the grammar from the file. The err is Errors when the file could be read but not parsed.
+func ParseFile(filePath string, options Options) (grammar *Grammar, err error) {
+	content, readErr := os.ReadFile(filePath)
+	if readErr != nil {
+		err = Errors{&DiagErr{
+			Loc: Loc{FilePath: filePath},
+			Code: CodeInclude,
+			Err: readErr,
+		}}
+		return
+	}
+	grammar, err = ParseString(string(content), filePath, options)
+	return
+}
+
+// Parses the grammar from the content. The filePath is used for the locations in the errors and
+// to find the @include-d files. The err is always Errors.
+func ParseString(content string, filePath string, options Options) (grammar *Grammar, err error) {
+	syntax := options.Syntax
+	if syntax == nil {
+		syntax = SyntaxOfFile(filePath)
+	}
+	core := map[string]Rule{}
+	if !options.NoCore && (syntax.Core || options.Core) {
+		core = CoreGrammar()
+	}
+
+	rules := map[string]Rule{}
+	lexer := NewLexer(content, filePath, syntax)
+	errs := ParseRules(&lexer, rules, core)
+	if len(core) > 0 {
+		lexer.Sources[CoreFilePath] = []rune(CoreContent)
+	}
+	coreNames := []string{}
+	for name, rule := range core {
+		if _, shadowed := rules[name]; !shadowed {
+			rules[name] = rule
+			coreNames = append(coreNames, name)
+		}
+	}
+	sort.Strings(coreNames)
+	ResolveNamespaces(rules)
+	exceptions := map[string]Errors{}
+	if len(errs) == 0 {
+		exceptions = LowerExceptions(rules)
+	}
+	maxRepetitions := uint(MaxUnspecifiedUpperRepetitionBound)
+	if options.MaxRepetitions != nil {
+		maxRepetitions = *options.MaxRepetitions
+	}
+	for name, rule := range rules {
+		rule.Body = SetImplicitUpperBound(rule.Body, maxRepetitions)
+		if options.Repetitions != nil {
+			rule.Body = SetDistribution(rule.Body, options.Repetitions)
+		}
+		rules[name] = rule
+	}
+	if len(errs) > 0 {
+		err = errs.WithSources(lexer.Sources)
+		return
+	}
+
+	grammar = &Grammar{
+		Rules: rules,
+		CoreNames: coreNames,
+		Sources: lexer.Sources,
+		Exceptions: exceptions,
+	}
+	return
+}
+
+func (grammar *Grammar) IsCore(name string) bool {
+	i := sort.SearchStrings(grammar.CoreNames, name)
+	return i < len(grammar.CoreNames) && grammar.CoreNames[i] == name
+}
+
+// Sorted names of the rules of the grammar without the core ones
+func (grammar *Grammar) Names() (names []string) {
+	for name := range grammar.Rules {
+		if !grammar.IsCore(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// Finds the symbols that are used but never defined, the rules that never finish and
+// the rules that expand into infinitely large messages on average. The latter are warnings.
+func (grammar *Grammar) Verify() (errs Errors) {
+	errs = VerifyThatAllSymbolsDefined(grammar.Rules)
+	errs = append(errs, VerifyThatAllSymbolsProductive(grammar.Rules)...)
+	errs = append(errs, VerifyThatExpectedSizesAreFinite(grammar.Rules)...)
+	errs.WithSources(grammar.Sources)
+	return
+}
+
+func VerifyBytesInExpr(expr Expr) (errs Errors) {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		return
+
+	case ExprAlternation:
+		for i := range expr.Variants {
+			errs = append(errs, VerifyBytesInExpr(expr.Variants[i])...)
+		}
+		return
+
+	case ExprConcat:
+		for i := range expr.Elements {
+			errs = append(errs, VerifyBytesInExpr(expr.Elements[i])...)
+		}
+		return
+
+	case ExprRepetition:
+		errs = VerifyBytesInExpr(expr.Body)
+		return
+
+	case ExprException:
+		errs = VerifyBytesInExpr(expr.Body)
+		errs = append(errs, VerifyBytesInExpr(expr.Except)...)
+		return
+
+	case ExprString:
+		for _, x := range expr.Text {
+			if err := ExpectByte(expr.Loc, x); err != nil {
+				errs.Add(err)
+				return
+			}
+		}
+		return
+
+	case ExprRange:
+		if err := ExpectByte(expr.Loc, expr.Upper); err != nil {
+			errs.Add(err)
+		}
+		return
+
+	case ExprCharClass:
+		if len(expr.Ranges) == 0 {
+			return
+		}
+		if err := ExpectByte(expr.Loc, expr.Ranges[len(expr.Ranges) - 1].Upper); err != nil {
+			errs.Add(err)
+		}
+		return
+
+	default: panic("unreachable")
+	}
+}
+
+// Finds the values that don't fit in a byte, so the -bytes mode fails before generating anything
+// rather than once the generator happens to reach them
+func (grammar *Grammar) VerifyBytes() (errs Errors) {
+	names := []string{}
+	for name := range grammar.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, VerifyBytesInExpr(grammar.Rules[name].Body)...)
+	}
+	errs.WithSources(grammar.Sources)
+	return
+}
+
+func (errs Errors) HasErrors() bool {
+	for _, err := range errs {
+		if err.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds the rules that are not reachable from the entry symbol. The core rules are never reported.
+func (grammar *Grammar) Unused(entry string) (errs Errors) {
+	rule, ok := grammar.Rules[entry]
+	if !ok {
+		errs.Add(&DiagErr{
+			Code: CodeUndefinedSymbol,
+			Err: fmt.Errorf("Symbol %s is not defined", entry),
+			Help: SymbolHelp(grammar.Rules, entry),
+		})
+		return
+	}
+	visited := map[string]bool{}
+	visited[entry] = true
+	WalkSymbolsInExpr(grammar.Rules, rule.Body, visited)
+
+	for _, name := range grammar.Names() {
+		if !visited[name] {
+			errs.Add(&DiagErr{
+				Loc: grammar.Rules[name].Head.Loc,
+				Severity: SeverityWarning,
+				Code: CodeUnusedSymbol,
+				Err: fmt.Errorf("%s is unused", name),
+			})
+		}
+	}
+	errs.WithSources(grammar.Sources)
+	return
+}
+
+// Finds the exceptions that can not be lowered into ExprCharClass in the rules reachable
+// from the entry symbol. The grammar can not be generated or matched from such an entry.
+func (grammar *Grammar) VerifyExceptions(entry string) (errs Errors) {
+	rule, ok := grammar.Rules[entry]
+	if !ok {
+		return
+	}
+	visited := map[string]bool{}
+	visited[entry] = true
+	WalkSymbolsInExpr(grammar.Rules, rule.Body, visited)
+
+	names := []string{}
+	for name := range grammar.Exceptions {
+		if visited[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, grammar.Exceptions[name]...)
+	}
+	errs.WithSources(grammar.Sources)
+	return
+}
+
+type Rule struct {
+	Head Token
+	Body Expr
+}
+
+func (rule Rule) String() string {
+	sep := ""
+	for i := range SyntaxMixed.LiteralTokens {
+		if SyntaxMixed.LiteralTokens[i].Kind == TokenDefinition {
+			sep = SyntaxMixed.LiteralTokens[i].Text
+			break
+		}
+	}
+	if len(sep) == 0 {
+		// This should be possible to check at compile time in 2023
+		panic("Not a single TokenAlternation exists to render ExprAlternation")
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(string(rule.Head.Text))
+	sb.WriteString(" "+sep+" ")
+	sb.WriteString(rule.Body.String())
+	return sb.String()
+}
+
+//go:embed core.abnf
+var CoreContent string
+
+const CoreFilePath = "core.abnf"
+
+// The core rules from RFC 5234 Appendix B.1 available in every grammar
+func CoreGrammar() map[string]Rule {
+	core := map[string]Rule{}
+	lexer := NewLexer(CoreContent, CoreFilePath, &SyntaxABNF)
+	if errs := ParseRules(&lexer, core, nil); len(errs) > 0 {
+		panic(fmt.Sprintf("Could not parse the core rules: %s", errs))
+	}
+	return core
+}
+
+func QualifySymbol(namespace string, name string) string {
+	if len(namespace) == 0 {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func UnqualifiedSymbol(namespace string, name string) string {
+	if len(namespace) == 0 {
+		return name
+	}
+	return strings.TrimPrefix(name, namespace + ".")
+}
+
+// Looks up the symbol referred from the namespace going from the innermost namespace to the global one
+func ResolveSymbol(grammar map[string]Rule, namespace string, name string) (string, bool) {
+	for {
+		qualified := QualifySymbol(namespace, name)
+		if _, exists := grammar[qualified]; exists {
+			return qualified, true
+		}
+		if len(namespace) == 0 {
+			return name, false
+		}
+		if i := strings.LastIndex(namespace, "."); i >= 0 {
+			namespace = namespace[:i]
+		} else {
+			namespace = ""
+		}
+	}
+}
+
+func ResolveNamespacesInExpr(grammar map[string]Rule, expr Expr) Expr {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		expr.Name, _ = ResolveSymbol(grammar, expr.Namespace, expr.Name)
+		expr.Namespace = ""
+		return expr
+	case ExprAlternation:
+		variants := []Expr{}
+		for i := range expr.Variants {
+			variants = append(variants, ResolveNamespacesInExpr(grammar, expr.Variants[i]))
+		}
+		expr.Variants = variants
+		return expr
+	case ExprConcat:
+		elements := []Expr{}
+		for i := range expr.Elements {
+			elements = append(elements, ResolveNamespacesInExpr(grammar, expr.Elements[i]))
+		}
+		expr.Elements = elements
+		return expr
+	case ExprRepetition:
+		expr.Body = ResolveNamespacesInExpr(grammar, expr.Body)
+		return expr
+	case ExprException:
+		expr.Body = ResolveNamespacesInExpr(grammar, expr.Body)
+		expr.Except = ResolveNamespacesInExpr(grammar, expr.Except)
+		return expr
+	}
+	return expr
+}
+
+// The symbols referred from the files included with a namespace can be resolved only after
+// the whole grammar is parsed, because they may refer to the rules defined further in the file
+func ResolveNamespaces(grammar map[string]Rule) {
+	resolved := map[string]Rule{}
+	for name, rule := range grammar {
+		rule.Body = ResolveNamespacesInExpr(grammar, rule.Body)
+		resolved[name] = rule
+	}
+	for name, rule := range resolved {
+		grammar[name] = rule
+	}
+}
+
+// The cleaned absolute path to compare the paths of the files
+func CanonicalPath(filePath string) string {
+	if abs, err := filepath.Abs(filePath); err == nil {
+		return abs
+	}
+	return filepath.Clean(filePath)
+}
+
+type IncludeKey struct {
+	FilePath string
+	Namespace string
+}
+
+// @include "path/to/file.bnf" [as namespace]
+//
+// Every file is included only once per namespace, so the layered grammars may include the same
+// file from several places
+func ParseInclude(lexer *Lexer, grammar map[string]Rule, base map[string]Rule) (errs Errors, err error) {
+	var path Token
+	path, err = ExpectToken(lexer, TokenString)
+	if err != nil {
+		return
+	}
+
+	namespace := lexer.Namespace
+	var token Token
+	token, err = lexer.Peek()
+	if err != nil {
+		return
+	}
+	if token.Kind == TokenSymbol && string(token.Text) == "as" {
+		lexer.PeekFull = false
+		var name Token
+		name, err = ExpectToken(lexer, TokenSymbol)
+		if err != nil {
+			return
+		}
+		namespace = QualifySymbol(namespace, string(name.Text))
+	}
+
+	err = ExpectRuleEnd(lexer)
+	if err != nil {
+		return
+	}
+
+	filePath := string(path.Text)
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(filepath.Dir(lexer.FilePath), filePath)
+	}
+	filePath = filepath.Clean(filePath)
+	canonicalPath := CanonicalPath(filePath)
+	for parent := lexer; parent != nil; parent = parent.IncludedFrom {
+		if CanonicalPath(parent.FilePath) == canonicalPath {
+			err = &DiagErr{
+				Loc: path.Loc,
+				Code: CodeInclude,
+				Err: fmt.Errorf("%s includes itself", filePath),
+			}
+			return
+		}
+	}
+
+	if lexer.Included == nil {
+		lexer.Included = map[IncludeKey]bool{}
+	}
+	key := IncludeKey{FilePath: canonicalPath, Namespace: namespace}
+	if lexer.Included[key] {
+		return
+	}
+	lexer.Included[key] = true
+
+	content, readErr := os.ReadFile(filePath)
+	if readErr != nil {
+		err = &DiagErr{
+			Loc: path.Loc,
+			Code: CodeInclude,
+			Err: readErr,
+		}
+		return
+	}
+
+	syntax := SyntaxOfFile(filePath)
+	if syntax == &SyntaxMixed {
+		syntax = lexer.Syntax
+	}
+	included := NewLexer(string(content), filePath, syntax)
+	included.Namespace = namespace
+	included.IncludedFrom = lexer
+	included.Included = lexer.Included
+	included.Sources = lexer.Sources
+	included.Sources[filePath] = included.Content
+	errs = ParseRules(&included, grammar, base)
+	return
+}
+
+// Adds the error and skips the rest of the rule adding the lexing errors found there as well
+func (errs *Errors) AddAndSkipRule(lexer *Lexer, err error) {
+	errs.Add(err)
+	for _, err := range lexer.SkipRule() {
+		errs.Add(err)
+	}
+}
+
+// Parses the rules from the lexer into the grammar. The rules of the base grammar can be shadowed
+// by the new definitions or extended with incremental alternatives.
+func ParseRules(lexer *Lexer, grammar map[string]Rule, base map[string]Rule) (errs Errors) {
+	for {
+		token, err := lexer.Peek()
+		if err == nil && token.Kind == TokenEOF {
+			break
+		}
+		if err == nil && token.Kind == TokenEOL {
+			lexer.PeekFull = false
+			continue
+		}
+
+		if err == nil && token.Kind == TokenDirective {
+			lexer.PeekFull = false
+			if string(token.Text) != "include" {
+				errs.AddAndSkipRule(lexer, &DiagErr{
+					Loc: token.Loc,
+					Len: len(token.Text) + 1,
+					Code: CodeUnknownDirective,
+					Err: fmt.Errorf("Unknown directive @%s", string(token.Text)),
+				})
+				continue
+			}
+			var includeErrs Errors
+			includeErrs, err = ParseInclude(lexer, grammar, base)
+			errs = append(errs, includeErrs...)
+			if err != nil {
+				errs.AddAndSkipRule(lexer, err)
+			}
+			continue
+		}
+
+		var head Token
+		head, err = ExpectToken(lexer, TokenSymbol)
+		if err != nil {
+			errs.AddAndSkipRule(lexer, err)
+			continue
+		}
+
+		var def Token
+		def, err = lexer.Next()
+		if err != nil {
+			errs.AddAndSkipRule(lexer, err)
+			continue
+		}
+
+		headText := head.Text
+		symbol := QualifySymbol(lexer.Namespace, string(head.Text))
+		head.Text = []rune(symbol)
+		existingRule, ruleExists := grammar[symbol]
+		baseRule, baseRuleExists := base[symbol]
+		if def.Kind == TokenIncAlternative && !ruleExists {
+			// Incremental alternatives may extend the rules of the files that included this one
+			var outerSymbol string
+			outerSymbol, ruleExists = ResolveSymbol(grammar, lexer.Namespace, UnqualifiedSymbol(lexer.Namespace, symbol))
+			if ruleExists {
+				symbol = outerSymbol
+				existingRule = grammar[symbol]
+			} else {
+				symbol = UnqualifiedSymbol(lexer.Namespace, symbol)
+				baseRule, baseRuleExists = base[symbol]
+			}
+		}
+
+		switch def.Kind {
+		case TokenDefinition:
+			if ruleExists {
+				errs.AddAndSkipRule(lexer, &DiagErr{
+					Loc: head.Loc,
+					Len: len(headText),
+					Code: CodeRedefinition,
+					Err: fmt.Errorf("redefinition of the rule %s", symbol),
+					Notes: []DiagNote{{
+						Loc: existingRule.Head.Loc,
+						Message: "the first definition is located here",
+					}},
+				})
+				continue
+			}
+
+			var body Expr
+			body, err = ParseExpr(lexer)
+			if err != nil {
+				errs.AddAndSkipRule(lexer, err)
+				continue
+			}
+
+			grammar[symbol] = Rule{
+				Head: head,
+				Body: body,
+			}
+
+		case TokenIncAlternative:
+			if !ruleExists && baseRuleExists {
+				existingRule, ruleExists = baseRule, true
+			}
+			if !ruleExists {
+				errs.AddAndSkipRule(lexer, &DiagErr{
+					Loc: head.Loc,
+					Len: len(headText),
+					Code: CodeUndefinedIncAlternative,
+					Err: fmt.Errorf("can't apply incremental alternative to a non-existing rule %s. You need to define it first.", symbol),
+					Help: SymbolHelp(grammar, symbol),
+				})
+				continue
+			}
+
+			var body Expr
+			body, err = ParseExpr(lexer)
+			if err != nil {
+				errs.AddAndSkipRule(lexer, err)
+				continue
+			}
+
+			existingRule.Body = WithCharClass(JoinAlternatives(existingRule.Body, body))
+
+			grammar[symbol] = existingRule
+		default:
+			errs.AddAndSkipRule(lexer, &DiagErr{
+				Loc: def.Loc,
+				Len: len(def.Text),
+				Err: fmt.Errorf("Expected %s or %s but got %s",
+					TokenKindName[TokenDefinition], TokenKindName[TokenIncAlternative],
+					TokenKindName[def.Kind]),
+			})
+			continue
+		}
+
+		err = ExpectRuleEnd(lexer)
+		if err != nil {
+			errs.AddAndSkipRule(lexer, err)
+			continue
+		}
+	}
+
+	return
+}
+
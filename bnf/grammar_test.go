@@ -0,0 +1,251 @@
+package bnf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func MustParse(t *testing.T, content string, filePath string) *Grammar {
+	t.Helper()
+	grammar, err := ParseString(content, filePath, Options{})
+	if err != nil {
+		t.Fatalf("%s: %s", filePath, err)
+	}
+	return grammar
+}
+
+func TestParseString(t *testing.T) {
+	tests := []struct {
+		name string
+		syntax *Syntax
+		content string
+		rules []string
+		ok bool
+	}{
+		{"abnf", &SyntaxABNF, "a = \"x\" b\n  / %d65.66\nb = %i\"y\" / %x30-39\n", []string{"a", "b"}, true},
+		{"bnf", &SyntaxBNF, "<a> ::= \"x\" | <b>\n<b> ::= \"y\"\n", []string{"a", "b"}, true},
+		{"ebnf", &SyntaxEBNF, "a = \"x\", { b } ;\nb = \"y\" | \"z\" ;\n", []string{"a", "b"}, true},
+		{"w3c", &SyntaxW3C, "a ::= [a-z]+ b?\nb ::= #x30 - #x31\n", []string{"a", "b"}, true},
+		{"incremental", &SyntaxABNF, "a = \"x\"\na =/ \"y\"\n", []string{"a"}, true},
+		{"redefinition", &SyntaxABNF, "a = \"x\"\na = \"y\"\n", nil, false},
+		{"unclosed", &SyntaxABNF, "a = ( \"x\"\n", nil, false},
+		{"undefined incremental", &SyntaxABNF, "a =/ \"x\"\n", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grammar, err := ParseString(test.content, "test", Options{Syntax: test.syntax, NoCore: true})
+			if !test.ok {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			names := grammar.Names()
+			if len(names) != len(test.rules) {
+				t.Fatalf("expected the rules %v, got %v", test.rules, names)
+			}
+			for i := range names {
+				if names[i] != test.rules[i] {
+					t.Fatalf("expected the rules %v, got %v", test.rules, names)
+				}
+			}
+		})
+	}
+}
+
+func TestParseStringKeepsCharAlternations(t *testing.T) {
+	grammar := MustParse(t, "cmd = \"a\" / \"b\"\n", "test.abnf")
+	alt, ok := grammar.Rules["cmd"].Body.(ExprAlternation)
+	if !ok {
+		t.Fatalf("expected an alternation, got %T", grammar.Rules["cmd"].Body)
+	}
+	if alt.Class == nil || alt.Class.Size() != 4 {
+		t.Fatalf("expected the class of 4 characters, got %v", alt.Class)
+	}
+
+	profile, err := ParseProfile(`{"rules": {"cmd": {"alternatives": {"0": 100}}}}`, "test.json")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if errs := grammar.ApplyProfile(profile); len(errs) > 0 {
+		t.Fatalf("%s", errs)
+	}
+}
+
+func TestIncludeOnce(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"uri.abnf": "host = 1*ALPHA\n",
+		"headers.abnf": "@include \"uri.abnf\"\nheader = \"Host: \" host\n",
+		"msg.abnf": "@include \"uri.abnf\"\n@include \"./headers.abnf\"\n@include \"uri.abnf\" as u\nmsg = header u.host\n",
+		"self.abnf": "@include \"" + filepath.Join(dir, ".", "self.abnf") + "\"\nx = \"a\"\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	grammar, err := ParseFile(filepath.Join(dir, "msg.abnf"), Options{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for _, name := range []string{"host", "u.host", "header", "msg"} {
+		if _, ok := grammar.Rules[name]; !ok {
+			t.Errorf("%s is not defined", name)
+		}
+	}
+	_, err = ParseFile(filepath.Join(dir, "self.abnf"), Options{})
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 1 || errs[0].GetCode() != CodeInclude {
+		t.Fatalf("expected a single %s error, got %v", CodeInclude, err)
+	}
+}
+
+func TestCoreRules(t *testing.T) {
+	tests := []struct {
+		syntax *Syntax
+		options Options
+		core bool
+	}{
+		{&SyntaxABNF, Options{}, true},
+		{&SyntaxMixed, Options{}, true},
+		{&SyntaxBNF, Options{}, false},
+		{&SyntaxEBNF, Options{}, false},
+		{&SyntaxW3C, Options{}, false},
+		{&SyntaxW3C, Options{Core: true}, true},
+		{&SyntaxABNF, Options{NoCore: true}, false},
+	}
+	for _, test := range tests {
+		test.options.Syntax = test.syntax
+		grammar, err := ParseString("", "test", test.options)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if _, ok := grammar.Rules["DIGIT"]; ok != test.core {
+			t.Errorf("%s with %+v: expected the core rules %t, got %t", test.syntax.Name, test.options, test.core, ok)
+		}
+	}
+}
+
+func TestVerifyBytes(t *testing.T) {
+	grammar := MustParse(t, "a = 99\"x\" / b / %xFF / \"\\xff\"\nb = %x100 / %x20-1FF / %s\"\u0100\"\n", "test.abnf")
+	errs := grammar.VerifyBytes()
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", errs)
+	}
+	for _, err := range errs {
+		if err.Code != CodeByteValue || err.Loc.Row != 1 {
+			t.Errorf("expected %s on the second line, got %s", CodeByteValue, err)
+		}
+	}
+
+	// Only the ASCII letters are case-insensitive, ÿ must not become Ÿ (U+0178)
+	grammar = MustParse(t, "a = \"\u00ff\" / %x41\nb = \"x\u00ffz\"\n", "test.abnf")
+	if errs := grammar.VerifyBytes(); len(errs) > 0 {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+	gen := NewGenerator(grammar, 1)
+	gen.Bytes = true
+	for i := 0; i < 100; i += 1 {
+		for _, entry := range []string{"a", "b"} {
+			message, _, err := gen.Next(entry)
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			for _, x := range message {
+				if x > 0xFF {
+					t.Fatalf("%s: %q does not fit in the bytes", entry, string(message))
+				}
+			}
+		}
+	}
+}
+
+func TestMaxRepetitions(t *testing.T) {
+	zero, five := uint(0), uint(5)
+	tests := []struct {
+		max *uint
+		rule string
+		upper uint
+	}{
+		{nil, "a = *\"x\"\n", MaxUnspecifiedUpperRepetitionBound},
+		{&five, "a = *\"x\"\n", 5},
+		{&zero, "a = *\"x\"\n", 0},
+		{&zero, "a = 2*\"x\"\n", 2},
+		{&zero, "a = *3\"x\"\n", 3},
+	}
+	for _, test := range tests {
+		grammar, err := ParseString(test.rule, "test.abnf", Options{MaxRepetitions: test.max})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if upper := grammar.Rules["a"].Body.(ExprRepetition).Upper; upper != test.upper {
+			t.Errorf("%q: expected the upper bound %d, got %d", test.rule, test.upper, upper)
+		}
+	}
+}
+
+func TestCaseSensitivity(t *testing.T) {
+	tests := []struct {
+		content string
+		filePath string
+		caseInsensitive bool
+	}{
+		{"a = \"x\"\n", "test.bnf", false},
+		{"a ::= \"x\"\n", "test.bnf", false},
+		{"a = %i\"x\"\n", "test.bnf", true},
+		{"a = \"x\"\n", "test.abnf", true},
+		{"a = %s\"x\"\n", "test.abnf", false},
+	}
+	for _, test := range tests {
+		grammar := MustParse(t, test.content, test.filePath)
+		if str := grammar.Rules["a"].Body.(ExprString); str.CaseInsensitive != test.caseInsensitive {
+			t.Errorf("%s %q: expected the case-insensitive %t, got %t", test.filePath, test.content, test.caseInsensitive, str.CaseInsensitive)
+		}
+	}
+}
+
+func TestVerifyExceptions(t *testing.T) {
+	content := `
+letter = "a" | "b" | "c" ;
+word = letter, { letter } ;
+keyword = "if" | "do" ;
+identifier = word - keyword ;
+vowel = letter - "b" ;
+syllable = vowel, letter ;
+statement = keyword, " ", identifier ;
+`
+	grammar := MustParse(t, content, "test.ebnf")
+	tests := []struct {
+		entry string
+		errors int
+	}{
+		{"syllable", 0},
+		{"word", 0},
+		{"identifier", 1},
+		{"statement", 1},
+	}
+	for _, test := range tests {
+		errs := grammar.VerifyExceptions(test.entry)
+		if len(errs) != test.errors {
+			t.Errorf("%s: expected %d errors, got %v", test.entry, test.errors, errs)
+			continue
+		}
+		for _, err := range errs {
+			if err.Code != CodeUnsupportedException {
+				t.Errorf("%s: expected %s, got %s", test.entry, CodeUnsupportedException, err.Code)
+			}
+		}
+	}
+
+	if _, err := NewRecognizer(grammar, "syllable"); err != nil {
+		t.Errorf("syllable: %s", err)
+	}
+	if _, err := NewRecognizer(grammar, "identifier"); err == nil {
+		t.Errorf("identifier: expected the recognizer to fail")
+	}
+}
@@ -0,0 +1,935 @@
+package bnf
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+type Loc struct {
+	FilePath string
+	Row int
+	Col int
+}
+
+
+func (loc Loc) String() string {
+	return fmt.Sprintf("%s:%d:%d", loc.FilePath, loc.Row + 1, loc.Col + 1)
+}
+
+type Lexer struct {
+	Content  []rune
+	FilePath string
+	Pos int
+	Row int
+	Bol int
+	PeekBuf  Token
+	PeekFull bool
+	// Whether the last chopped token ended the rule
+	RuleEnded bool
+	Syntax *Syntax
+	// Prefix of the names of the rules defined by the lexer. Set for the files included with a namespace.
+	Namespace string
+	// The lexer of the file that included this one
+	IncludedFrom *Lexer
+	// The files included so far with their namespaces. Shared by all of the lexers of the grammar.
+	Included map[IncludeKey]bool
+	// Whether the string literals without %s or %i prefix are case-insensitive
+	CaseInsensitive bool
+	// The contents of the files read so far. Shared by all of the lexers of the grammar.
+	Sources Sources
+}
+
+func NewLexer(content string, filePath string, syntax *Syntax) Lexer {
+	runes := []rune(content)
+	return Lexer{
+		Content: runes,
+		Sources: Sources{filePath: runes},
+		FilePath: filePath,
+		Syntax: syntax,
+		CaseInsensitive: syntax.CaseInsensitive,
+	}
+}
+
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenEOL
+	TokenSymbol
+	TokenDefinition
+	TokenAlternation
+	TokenString
+	TokenBracketOpen
+	TokenBracketClose
+	TokenCurlyOpen
+	TokenCurlyClose
+	TokenParenOpen
+	TokenParenClose
+	TokenEllipsis
+	TokenNumber
+	TokenAsterisk
+	TokenIncAlternative
+	TokenValueRange
+	TokenConcatenation
+	TokenTerminator
+	TokenException
+	TokenRepetition
+	TokenOptional
+	TokenOneOrMore
+	TokenZeroOrMore
+	TokenCharClass
+	TokenDirective
+	TokenWeight
+)
+
+var TokenKindName = map[TokenKind]string{
+	TokenEOF: "end of file",
+	TokenEOL: "end of line",
+	TokenSymbol: "symbol",
+	TokenDefinition: "definition symbol",
+	TokenAlternation: "alternation symbol",
+	TokenString: "string literal",
+	TokenBracketOpen: "open bracket",
+	TokenBracketClose: "close bracket",
+	TokenCurlyOpen: "open curly",
+	TokenCurlyClose: "close curly",
+	TokenParenOpen: "open paren",
+	TokenParenClose: "close paren",
+	TokenEllipsis: "ellipsis",
+	TokenNumber: "number",
+	TokenAsterisk: "asterisk",
+	TokenIncAlternative: "incremental alternative",
+	TokenValueRange: "value range",
+	TokenConcatenation: "concatenation symbol",
+	TokenTerminator: "rule terminator",
+	TokenException: "exception symbol",
+	TokenRepetition: "repetition symbol",
+	TokenOptional: "optional symbol",
+	TokenOneOrMore: "one or more symbol",
+	TokenZeroOrMore: "zero or more symbol",
+	TokenCharClass: "character class",
+	TokenDirective: "directive",
+	TokenWeight: "weight",
+}
+
+type LiteralToken struct {
+	Text string
+	Kind TokenKind
+}
+
+type BlockComment struct {
+	Open string
+	Close string
+}
+
+type Syntax struct {
+	Name string
+	LiteralTokens []LiteralToken
+	LineComments []string
+	BlockComments []BlockComment
+	IsSymbolStart func(ch rune) bool
+	IsSymbol func(ch rune) bool
+	// Symbol names may consist of several words separated by spaces like in ISO 14977
+	SpacedSymbols bool
+	// Symbols wrapped in angle brackets like <symbol>
+	AngleSymbols bool
+	// %x, %d, %b values from RFC 5234 and %i, %s strings from RFC 7405
+	NumValues bool
+	// Repetition counts like 3*5
+	Numbers bool
+	// Code points like #x20
+	CodePoints bool
+	// Character classes like [a-zA-Z_] and [^"<&]
+	CharClasses bool
+	// Rules are ended with TokenTerminator instead of non-indented lines
+	Terminated bool
+	// Whether the string literals without %s or %i prefix are case-insensitive
+	CaseInsensitive bool
+	// The RFC 5234 core rules are provided unless Options.NoCore
+	Core bool
+}
+
+// The syntax we supported historically. Mixes up BNF and ABNF.
+var SyntaxMixed = Syntax{
+	Name: "mixed",
+	LiteralTokens: []LiteralToken{
+		{ Text: "::=", Kind: TokenDefinition },
+		{ Text: "=/", Kind: TokenIncAlternative },
+		{ Text: "=", Kind: TokenDefinition },
+		{ Text: "|", Kind: TokenAlternation },
+		{ Text: "/", Kind: TokenAlternation },
+		{ Text: "[", Kind: TokenBracketOpen },
+		{ Text: "]", Kind: TokenBracketClose },
+		{ Text: "{", Kind: TokenCurlyOpen },
+		{ Text: "}", Kind: TokenCurlyClose },
+		{ Text: "(", Kind: TokenParenOpen },
+		{ Text: ")", Kind: TokenParenClose },
+		{ Text: "...", Kind: TokenEllipsis },
+		{ Text: "*", Kind: TokenAsterisk },
+	},
+	LineComments: []string{ "//", ";" },
+	IsSymbolStart: IsSymbolStart,
+	IsSymbol: IsSymbol,
+	AngleSymbols: true,
+	NumValues: true,
+	Numbers: true,
+	Core: true,
+}
+
+var SyntaxBNF = Syntax{
+	Name: "bnf",
+	LiteralTokens: []LiteralToken{
+		{ Text: "::=", Kind: TokenDefinition },
+		{ Text: "|", Kind: TokenAlternation },
+		{ Text: "[", Kind: TokenBracketOpen },
+		{ Text: "]", Kind: TokenBracketClose },
+		{ Text: "{", Kind: TokenCurlyOpen },
+		{ Text: "}", Kind: TokenCurlyClose },
+		{ Text: "(", Kind: TokenParenOpen },
+		{ Text: ")", Kind: TokenParenClose },
+		{ Text: "...", Kind: TokenEllipsis },
+	},
+	LineComments: []string{ "//", ";" },
+	IsSymbolStart: IsSymbolStart,
+	IsSymbol: IsSymbol,
+	AngleSymbols: true,
+}
+
+// RFC 5234
+var SyntaxABNF = Syntax{
+	Name: "abnf",
+	LiteralTokens: []LiteralToken{
+		{ Text: "=/", Kind: TokenIncAlternative },
+		{ Text: "=", Kind: TokenDefinition },
+		{ Text: "/", Kind: TokenAlternation },
+		{ Text: "[", Kind: TokenBracketOpen },
+		{ Text: "]", Kind: TokenBracketClose },
+		{ Text: "(", Kind: TokenParenOpen },
+		{ Text: ")", Kind: TokenParenClose },
+		{ Text: "*", Kind: TokenAsterisk },
+	},
+	LineComments: []string{ ";" },
+	IsSymbolStart: IsSymbolStart,
+	IsSymbol: IsSymbol,
+	AngleSymbols: true,
+	NumValues: true,
+	Numbers: true,
+	CaseInsensitive: true,
+	Core: true,
+}
+
+// ISO/IEC 14977
+var SyntaxEBNF = Syntax{
+	Name: "ebnf",
+	LiteralTokens: []LiteralToken{
+		{ Text: "(/", Kind: TokenBracketOpen },
+		{ Text: "/)", Kind: TokenBracketClose },
+		{ Text: "(:", Kind: TokenCurlyOpen },
+		{ Text: ":)", Kind: TokenCurlyClose },
+		{ Text: "=", Kind: TokenDefinition },
+		{ Text: "|", Kind: TokenAlternation },
+		{ Text: "/", Kind: TokenAlternation },
+		{ Text: "!", Kind: TokenAlternation },
+		{ Text: ",", Kind: TokenConcatenation },
+		{ Text: ";", Kind: TokenTerminator },
+		{ Text: ".", Kind: TokenTerminator },
+		{ Text: "-", Kind: TokenException },
+		{ Text: "*", Kind: TokenRepetition },
+		{ Text: "[", Kind: TokenBracketOpen },
+		{ Text: "]", Kind: TokenBracketClose },
+		{ Text: "{", Kind: TokenCurlyOpen },
+		{ Text: "}", Kind: TokenCurlyClose },
+		{ Text: "(", Kind: TokenParenOpen },
+		{ Text: ")", Kind: TokenParenClose },
+	},
+	BlockComments: []BlockComment{
+		{ Open: "(*", Close: "*)" },
+	},
+	IsSymbolStart: unicode.IsLetter,
+	IsSymbol: func(ch rune) bool {
+		return unicode.IsLetter(ch) || unicode.IsNumber(ch) || ch == '_'
+	},
+	SpacedSymbols: true,
+	Numbers: true,
+	Terminated: true,
+}
+
+// The notation of the W3C specifications https://www.w3.org/TR/xml/#sec-notation
+var SyntaxW3C = Syntax{
+	Name: "w3c",
+	LiteralTokens: []LiteralToken{
+		{ Text: "::=", Kind: TokenDefinition },
+		{ Text: "|", Kind: TokenAlternation },
+		{ Text: "-", Kind: TokenException },
+		{ Text: "?", Kind: TokenOptional },
+		{ Text: "+", Kind: TokenOneOrMore },
+		{ Text: "*", Kind: TokenZeroOrMore },
+		{ Text: "(", Kind: TokenParenOpen },
+		{ Text: ")", Kind: TokenParenClose },
+	},
+	BlockComments: []BlockComment{
+		{ Open: "/*", Close: "*/" },
+	},
+	IsSymbolStart: func(ch rune) bool {
+		return unicode.IsLetter(ch) || ch == '_'
+	},
+	IsSymbol: func(ch rune) bool {
+		return unicode.IsLetter(ch) || unicode.IsNumber(ch) || ch == '_'
+	},
+	AngleSymbols: true,
+	CodePoints: true,
+	CharClasses: true,
+}
+
+var Syntaxes = map[string]*Syntax{
+	SyntaxBNF.Name: &SyntaxBNF,
+	SyntaxABNF.Name: &SyntaxABNF,
+	SyntaxEBNF.Name: &SyntaxEBNF,
+	SyntaxW3C.Name: &SyntaxW3C,
+}
+
+// Picks the syntax by the extension of the file. Falls back to SyntaxMixed.
+// .bnf files are not detected, because historically they are written in the mixed syntax.
+func SyntaxOfFile(filePath string) *Syntax {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".abnf":
+		return &SyntaxABNF
+	case ".ebnf":
+		return &SyntaxEBNF
+	case ".w3c":
+		return &SyntaxW3C
+	}
+	return &SyntaxMixed
+}
+
+type Token struct {
+	Kind TokenKind
+	Text []rune
+	Number uint
+	CaseInsensitive bool
+	Negated bool
+	Loc Loc
+}
+
+func (lexer *Lexer) Trim() {
+	for lexer.Pos < len(lexer.Content) && lexer.Content[lexer.Pos] != '\n' && unicode.IsSpace(lexer.Content[lexer.Pos]) {
+		lexer.Pos += 1
+	}
+}
+
+func (lexer *Lexer) SkipLine() {
+	for lexer.Pos < len(lexer.Content) && lexer.Content[lexer.Pos] != '\n' {
+		lexer.Pos += 1
+	}
+	if lexer.Pos < len(lexer.Content) {
+		lexer.Pos += 1
+		lexer.Row += 1
+		lexer.Bol = lexer.Pos
+	}
+}
+
+// A rule ends only when the next non-indented line starts a new definition or a directive.
+// Indented lines, blank lines and comments are continuations of the current rule.
+func (lexer *Lexer) StartsRule() bool {
+	if lexer.Pos >= len(lexer.Content) || unicode.IsSpace(lexer.Content[lexer.Pos]) {
+		return false
+	}
+	probe := *lexer
+	probe.PeekFull = false
+	head, err := probe.ChopToken()
+	if err == nil && head.Kind == TokenDirective {
+		return true
+	}
+	if err != nil || head.Kind != TokenSymbol {
+		return false
+	}
+	def, err := probe.ChopToken()
+	return err == nil && (def.Kind == TokenDefinition || def.Kind == TokenIncAlternative)
+}
+
+// SkipRule skips the rest of the current rule to recover after an error.
+// Returns the lexing errors found along the way, so several of them can be reported at once.
+func (lexer *Lexer) SkipRule() (errs []error) {
+	lexer.PeekFull = false
+	if lexer.RuleEnded {
+		return
+	}
+	for {
+		_, err := lexer.ChopToken()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if lexer.RuleEnded {
+			return
+		}
+	}
+}
+
+func (lexer *Lexer) Index(x rune) int {
+	for i := lexer.Pos; i < len(lexer.Content); i += 1 {
+		if lexer.Content[i] == x {
+			return i
+		}
+	}
+	return -1
+}
+
+func (lexer *Lexer) Prefix(prefix []rune) bool {
+	for i := range prefix {
+		if lexer.Pos+i >= len(lexer.Content) {
+			return false
+		}
+		if lexer.Content[lexer.Pos+i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (lexer *Lexer) Loc() Loc {
+	return Loc{
+		FilePath: lexer.FilePath,
+		Row: lexer.Row,
+		Col: lexer.Pos - lexer.Bol,
+	}
+}
+
+func (lexer *Lexer) ChopHexByteValue() (result rune, err error) {
+	for i := 0; i < 2; i += 1 {
+		if lexer.Pos >= len(lexer.Content) {
+			err = &DiagErr{
+				Loc: lexer.Loc(),
+				Err: fmt.Errorf("Unfinished hexadecimal value of a byte. Expected 2 hex digits, but got %d.", i),
+			}
+			return
+		}
+		x := lexer.Content[lexer.Pos]
+		if '0' <= x && x <= '9' {
+			result = result*0x10 + x - '0'
+		} else if 'a' <= x && x <= 'f' {
+			result = result*0x10 + x - 'a' + 10
+		} else if 'A' <= x && x <= 'F' {
+			result = result*0x10 + x - 'A' + 10
+		} else {
+			err = &DiagErr{
+				Loc: lexer.Loc(),
+				Err: fmt.Errorf("Expected hex digit, but got `%c`", x),
+			}
+			return
+		}
+		lexer.Pos += 1
+	}
+	return
+}
+
+type NumValueBase struct {
+	Radix rune
+	Name string
+}
+
+var NumValueBases = map[rune]NumValueBase{
+	'x': { Radix: 16, Name: "hexadecimal" },
+	'd': { Radix: 10, Name: "decimal" },
+	'b': { Radix: 2, Name: "binary" },
+}
+
+func DigitValue(x rune) rune {
+	if '0' <= x && x <= '9' {
+		return x - '0'
+	} else if 'a' <= x && x <= 'z' {
+		return x - 'a' + 10
+	} else if 'A' <= x && x <= 'Z' {
+		return x - 'A' + 10
+	}
+	return -1
+}
+
+// Chops a numeric value of an arbitrary amount of digits like in RFC 5234 `%x10FFFF`, `%d13` or `%b0101`
+func (lexer *Lexer) ChopNumValue(base NumValueBase) (result rune, err error) {
+	begin := lexer.Pos
+	for lexer.Pos < len(lexer.Content) {
+		digit := DigitValue(lexer.Content[lexer.Pos])
+		if digit < 0 || digit >= base.Radix {
+			break
+		}
+		result = result*base.Radix + digit
+		if result > unicode.MaxRune {
+			err = &DiagErr{
+				Loc: lexer.Loc(),
+				Err: fmt.Errorf("The %s value is too big. The maximum allowed value is %d.", base.Name, unicode.MaxRune),
+			}
+			return
+		}
+		lexer.Pos += 1
+	}
+	if lexer.Pos == begin {
+		err = &DiagErr{
+			Loc: lexer.Loc(),
+			Err: fmt.Errorf("Expected %s digit", base.Name),
+		}
+		return
+	}
+	return
+}
+
+func (lexer *Lexer) ChopCodePoint() (result rune, err error) {
+	if !lexer.Prefix([]rune("#x")) {
+		err = &DiagErr{
+			Loc: lexer.Loc(),
+			Err: fmt.Errorf("Expected code point like #x20"),
+		}
+		return
+	}
+	lexer.Pos += 2
+	return lexer.ChopNumValue(NumValueBases['x'])
+}
+
+// Chops a character of a character class. It's either a code point like #x20 or a character itself.
+func (lexer *Lexer) ChopCharClassChar() (result rune, err error) {
+	if lexer.Pos >= len(lexer.Content) || lexer.Content[lexer.Pos] == '\n' {
+		err = &DiagErr{
+			Loc: lexer.Loc(),
+			Err: fmt.Errorf("Expected ']' at the end of the character class"),
+		}
+		return
+	}
+	if lexer.Prefix([]rune("#x")) {
+		return lexer.ChopCodePoint()
+	}
+	result = lexer.Content[lexer.Pos]
+	lexer.Pos += 1
+	return
+}
+
+// Chops W3C character class like [a-zA-Z_] or [^"<&] into pairs of lower and upper bounds
+func (lexer *Lexer) ChopCharClass() (token Token, err error) {
+	token.Kind = TokenCharClass
+	token.Loc = lexer.Loc()
+	lexer.Pos += 1
+	if lexer.Prefix([]rune("^")) {
+		token.Negated = true
+		lexer.Pos += 1
+	}
+	for !lexer.Prefix([]rune("]")) {
+		var lower, upper rune
+		lower, err = lexer.ChopCharClassChar()
+		if err != nil {
+			return
+		}
+		upper = lower
+		if lexer.Prefix([]rune("-")) && !lexer.Prefix([]rune("-]")) {
+			lexer.Pos += 1
+			upper, err = lexer.ChopCharClassChar()
+			if err != nil {
+				return
+			}
+		}
+		token.Text = append(token.Text, lower, upper)
+	}
+	lexer.Pos += 1
+	return
+}
+
+func (lexer *Lexer) ChopStrLit() (lit []rune, err error) {
+	if lexer.Pos >= len(lexer.Content) {
+		return
+	}
+
+	quote := lexer.Content[lexer.Pos]
+	lexer.Pos += 1
+	begin := lexer.Pos
+	var escErr error
+
+	loop: for lexer.Pos < len(lexer.Content) && lexer.Content[lexer.Pos] != '\n' {
+		if lexer.Content[lexer.Pos] == '\\' {
+			lexer.Pos += 1
+			if lexer.Pos >= len(lexer.Content) {
+				err = &DiagErr{
+					Loc: lexer.Loc(),
+					Err: fmt.Errorf("Unfinished escape sequence"),
+				}
+				return
+			}
+
+			switch lexer.Content[lexer.Pos] {
+			case '0':
+				lit = append(lit, 0)
+				lexer.Pos += 1
+			case 'n':
+				lit = append(lit, '\n')
+				lexer.Pos += 1
+			case 'r':
+				lit = append(lit, '\r')
+				lexer.Pos += 1
+			case '\\':
+				lit = append(lit, '\\')
+				lexer.Pos += 1
+			case 'x':
+				lexer.Pos += 1
+				value, hexErr := lexer.ChopHexByteValue()
+				if hexErr != nil {
+					// Keep going till the end of the literal so the rest of it is not mistaken for tokens
+					if escErr == nil {
+						escErr = hexErr
+					}
+					continue
+				}
+				lit = append(lit, value)
+			default:
+				if lexer.Content[lexer.Pos] == quote {
+					lit = append(lit, quote)
+					lexer.Pos += 1
+				} else {
+					if escErr == nil {
+						escErr = &DiagErr{
+							Loc: lexer.Loc(),
+							Len: 1,
+							Err: fmt.Errorf("Unknown escape sequence starting with %c", lexer.Content[lexer.Pos]),
+						}
+					}
+					lexer.Pos += 1
+				}
+			}
+		} else {
+			if lexer.Content[lexer.Pos] == quote {
+				break loop
+			}
+			lit = append(lit, lexer.Content[lexer.Pos])
+			lexer.Pos += 1
+		}
+	}
+
+	if lexer.Pos >= len(lexer.Content) || lexer.Content[lexer.Pos] != quote {
+		err = &DiagErr{
+			Loc: Loc{
+				FilePath: lexer.FilePath,
+				Row: lexer.Row,
+				Col: begin - lexer.Bol,
+			},
+			Len: lexer.Pos - begin,
+			Err: fmt.Errorf("Expected '%c' at the end of this string literal", quote),
+		}
+		return
+	}
+	lexer.Pos += 1
+	err = escErr
+
+	return
+}
+
+func IsSymbolStart(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '-' || ch == '_'
+}
+
+func IsSymbol(ch rune) bool {
+	return unicode.IsLetter(ch) || unicode.IsNumber(ch) || ch == '-' || ch == '_'
+}
+
+func (lexer *Lexer) ChopLineComment() bool {
+	for i := range lexer.Syntax.LineComments {
+		if lexer.Prefix([]rune(lexer.Syntax.LineComments[i])) {
+			for lexer.Pos < len(lexer.Content) && lexer.Content[lexer.Pos] != '\n' {
+				lexer.Pos += 1
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (lexer *Lexer) ChopBlockComment() (ok bool, err error) {
+	for i := range lexer.Syntax.BlockComments {
+		open := []rune(lexer.Syntax.BlockComments[i].Open)
+		close := []rune(lexer.Syntax.BlockComments[i].Close)
+		if !lexer.Prefix(open) {
+			continue
+		}
+		begin := lexer.Loc()
+		lexer.Pos += len(open)
+		for !lexer.Prefix(close) {
+			if lexer.Pos >= len(lexer.Content) {
+				err = &DiagErr{
+					Loc: begin,
+					Err: fmt.Errorf("Expected '%s' at the end of this comment", string(close)),
+				}
+				return
+			}
+			if lexer.Content[lexer.Pos] == '\n' {
+				lexer.SkipLine()
+			} else {
+				lexer.Pos += 1
+			}
+		}
+		lexer.Pos += len(close)
+		ok = true
+		return
+	}
+	return
+}
+
+// Chops the name of a symbol which may be qualified with namespaces like `uri.host`
+func (lexer *Lexer) ChopSymbolName() {
+	for {
+		for lexer.Pos < len(lexer.Content) && lexer.Syntax.IsSymbol(lexer.Content[lexer.Pos]) {
+			lexer.Pos += 1
+		}
+		if !lexer.Prefix([]rune(".")) || lexer.Pos+1 >= len(lexer.Content) || !lexer.Syntax.IsSymbolStart(lexer.Content[lexer.Pos+1]) {
+			return
+		}
+		lexer.Pos += 1
+	}
+}
+
+func (lexer *Lexer) ChopToken() (token Token, err error) {
+	begin := -1
+	defer func() {
+		lexer.RuleEnded = err == nil && (token.Kind == TokenEOL || token.Kind == TokenEOF || token.Kind == TokenTerminator)
+		// Make sure the lexer moves forward on errors, so chopping again does not report the same error
+		if err != nil && lexer.Pos == begin && lexer.Pos < len(lexer.Content) && lexer.Content[lexer.Pos] != '\n' {
+			lexer.Pos += 1
+		}
+	}()
+
+	for {
+		lexer.Trim()
+
+		var ok bool
+		ok, err = lexer.ChopBlockComment()
+		if err != nil {
+			return
+		}
+		if ok {
+			continue
+		}
+
+		lexer.ChopLineComment()
+
+		if lexer.Pos >= len(lexer.Content) || lexer.Content[lexer.Pos] != '\n' {
+			break
+		}
+
+		token.Loc = lexer.Loc()
+		lexer.SkipLine()
+		if !lexer.Syntax.Terminated && lexer.StartsRule() {
+			token.Kind = TokenEOL
+			return
+		}
+	}
+
+	token.Loc = lexer.Loc()
+	begin = lexer.Pos
+
+	if lexer.Pos >= len(lexer.Content) {
+		token.Kind = TokenEOF
+		return
+	}
+
+	if lexer.Syntax.Numbers && unicode.IsNumber(lexer.Content[lexer.Pos]) {
+		begin := lexer.Pos
+		token.Number = 0
+		for lexer.Pos < len(lexer.Content) && unicode.IsNumber(lexer.Content[lexer.Pos]) {
+			token.Number *= 10
+			token.Number += uint(lexer.Content[lexer.Pos] - '0')
+			lexer.Pos += 1
+		}
+		token.Kind = TokenNumber
+		token.Text = lexer.Content[begin:lexer.Pos]
+		return
+	}
+
+	if lexer.Syntax.IsSymbolStart(lexer.Content[lexer.Pos]) {
+		begin := lexer.Pos
+
+		lexer.ChopSymbolName()
+		token.Kind = TokenSymbol
+		token.Text = lexer.Content[begin:lexer.Pos]
+
+		if lexer.Syntax.SpacedSymbols {
+			for {
+				end := lexer.Pos
+				lexer.Trim()
+				if lexer.Pos >= len(lexer.Content) || lexer.Pos == end || !lexer.Syntax.IsSymbolStart(lexer.Content[lexer.Pos]) {
+					lexer.Pos = end
+					break
+				}
+				word := lexer.Pos
+				lexer.ChopSymbolName()
+				token.Text = append(append(append([]rune{}, token.Text...), ' '), lexer.Content[word:lexer.Pos]...)
+			}
+		}
+		return
+	}
+
+	// The weight of an alternative like `@10 "x" | "y"`
+	if lexer.Prefix([]rune("@")) && lexer.Pos + 1 < len(lexer.Content) && '0' <= lexer.Content[lexer.Pos + 1] && lexer.Content[lexer.Pos + 1] <= '9' {
+		lexer.Pos += 1
+		begin := lexer.Pos
+		token.Number = 0
+		for lexer.Pos < len(lexer.Content) && '0' <= lexer.Content[lexer.Pos] && lexer.Content[lexer.Pos] <= '9' {
+			token.Number *= 10
+			token.Number += uint(lexer.Content[lexer.Pos] - '0')
+			lexer.Pos += 1
+		}
+		token.Kind = TokenWeight
+		token.Text = lexer.Content[begin:lexer.Pos]
+		return
+	}
+
+	if lexer.Prefix([]rune("@")) {
+		lexer.Pos += 1
+		begin := lexer.Pos
+		for lexer.Pos < len(lexer.Content) && unicode.IsLetter(lexer.Content[lexer.Pos]) {
+			lexer.Pos += 1
+		}
+		token.Kind = TokenDirective
+		token.Text = lexer.Content[begin:lexer.Pos]
+		return
+	}
+
+	if lexer.Syntax.AngleSymbols && lexer.Content[lexer.Pos] == '<' {
+		begin := lexer.Pos + 1
+		lexer.Pos = begin
+		for lexer.Pos < len(lexer.Content) && lexer.Content[lexer.Pos] != '>' {
+			ch := lexer.Content[lexer.Pos]
+			if !IsSymbol(ch) && ch != '.' {
+				err = &DiagErr{
+					Loc: lexer.Loc(),
+					Err: fmt.Errorf("Unexpected character in symbol name %c", ch),
+				}
+				return
+			}
+			lexer.Pos += 1
+		}
+		if lexer.Pos >= len(lexer.Content) {
+			err = &DiagErr{
+				Loc: lexer.Loc(),
+				Err: fmt.Errorf("Expected '>' at the end of the symbol name"),
+			}
+			return
+		}
+
+		token.Kind = TokenSymbol
+		token.Text = lexer.Content[begin:lexer.Pos]
+		lexer.Pos += 1
+		return
+	}
+
+	if lexer.Content[lexer.Pos] == '"' || lexer.Content[lexer.Pos] == '\'' {
+		var lit []rune
+		lit, err = lexer.ChopStrLit()
+		if err != nil {
+			return
+		}
+		token.Kind = TokenString
+		token.Text = lit
+		token.CaseInsensitive = lexer.CaseInsensitive
+		return
+	}
+	if lexer.Syntax.CharClasses && lexer.Prefix([]rune("[")) {
+		return lexer.ChopCharClass()
+	}
+	if lexer.Syntax.CodePoints && lexer.Prefix([]rune("#x")) {
+		var value rune
+		value, err = lexer.ChopCodePoint()
+		if err != nil {
+			return
+		}
+		token.Kind = TokenString
+		token.Text = []rune{value}
+		return
+	}
+	if lexer.Syntax.NumValues && (lexer.Prefix([]rune("%i\"")) || lexer.Prefix([]rune("%s\""))) {
+		// RFC 7405
+		token.CaseInsensitive = lexer.Content[lexer.Pos+1] == 'i'
+		lexer.Pos += 2
+		var lit []rune
+		lit, err = lexer.ChopStrLit()
+		if err != nil {
+			return
+		}
+		token.Kind = TokenString
+		token.Text = lit
+		return
+	}
+	if lexer.Syntax.NumValues && lexer.Prefix([]rune("%")) && lexer.Pos+1 < len(lexer.Content) {
+		if base, ok := NumValueBases[unicode.ToLower(lexer.Content[lexer.Pos+1])]; ok {
+			lexer.Pos += 2
+
+			var value rune
+
+			value, err = lexer.ChopNumValue(base)
+			if err != nil {
+				return
+			}
+			token.Text = append(token.Text, value)
+
+			if lexer.Prefix([]rune("-")) {
+				lexer.Pos += 1
+
+				value, err = lexer.ChopNumValue(base)
+				if err != nil {
+					return
+				}
+				token.Text = append(token.Text, value)
+				token.Kind = TokenValueRange
+				return
+			}
+
+			for lexer.Prefix([]rune(".")) {
+				lexer.Pos += 1
+
+				value, err = lexer.ChopNumValue(base)
+				if err != nil {
+					return
+				}
+				token.Text = append(token.Text, value)
+			}
+			token.Kind = TokenString
+			return
+		}
+	}
+
+	for i := range lexer.Syntax.LiteralTokens {
+		runeName := []rune(lexer.Syntax.LiteralTokens[i].Text)
+		if lexer.Prefix(runeName) {
+			token.Kind = lexer.Syntax.LiteralTokens[i].Kind
+			token.Text = runeName
+			lexer.Pos += len(runeName)
+			return
+		}
+	}
+
+	err = &DiagErr{
+		Loc: lexer.Loc(),
+		Err: fmt.Errorf("Invalid token"),
+	}
+	return
+}
+
+func (lexer *Lexer) Peek() (token Token, err error) {
+	if !lexer.PeekFull {
+		token, err = lexer.ChopToken()
+		if err != nil {
+			return
+		}
+		lexer.PeekFull = true
+		lexer.PeekBuf = token
+	} else {
+		token = lexer.PeekBuf
+	}
+	return
+}
+
+func (lexer *Lexer) Next() (token Token, err error) {
+	if lexer.PeekFull {
+		token = lexer.PeekBuf
+		lexer.PeekFull = false
+		return
+	}
+
+	token, err = lexer.ChopToken()
+	return
+}
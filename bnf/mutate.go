@@ -0,0 +1,155 @@
+package bnf
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// A derivation of a rule inside of a seed
+type SeedNode struct {
+	Seed int
+	Tree *ParseTree
+	// How many rules are nested on the way to the Tree
+	Depth int
+}
+
+type MutatorSeed struct {
+	FilePath string
+	Input []rune
+	Nodes []SeedNode
+}
+
+// Mutates the inputs that match the grammar into the new inputs that still match it, like
+// Nautilus and Superion do. A mutant replaces a derivation of a rule in a seed either with a random
+// message of the same rule or with a derivation of the same rule from any of the seeds.
+type Mutator struct {
+	Generator *Generator
+	Recognizer *Recognizer
+	Seeds []MutatorSeed
+	// The derivations of the seeds by the Nonterminals of their rules
+	Nodes map[int][]SeedNode
+	// How many mutants were generated by Next so far
+	Count int
+	// How many times longer than the replaced part the random message may be, so the mutants
+	// stay close to the seeds. It is never less than MinRegeneratedSize. 0 means no limit
+	// besides the MaxSize of the Generator.
+	Growth int
+}
+
+const DefaultMutationGrowth = 4
+
+const MinRegeneratedSize = 16
+
+func NewMutator(gen *Generator, rec *Recognizer) *Mutator {
+	return &Mutator{
+		Generator: gen,
+		Recognizer: rec,
+		Nodes: map[int][]SeedNode{},
+		Growth: DefaultMutationGrowth,
+	}
+}
+
+// The size budget of the random message replacing the node
+func (mut *Mutator) RegeneratedSize(target MutatorSeed, node SeedNode) (size int) {
+	outside := node.Tree.Start + len(target.Input) - node.Tree.End
+	maxSize := mut.Generator.MaxSize
+	if maxSize > 0 {
+		// The message may already exceed the MaxSize, but it has to be finished somehow
+		size = maxSize - outside
+		if size < 1 {
+			size = 1
+		}
+	}
+	if mut.Growth > 0 {
+		growth := mut.Growth*(node.Tree.End - node.Tree.Start)
+		if growth < MinRegeneratedSize {
+			growth = MinRegeneratedSize
+		}
+		if size == 0 || growth < size {
+			size = growth
+		}
+	}
+	return
+}
+
+// Parses the input into a derivation tree. The inputs that don't match are reported like in
+// Recognizer.Match and are not used as seeds.
+func (mut *Mutator) AddSeed(input []rune, filePath string) (err error) {
+	chart, err := mut.Recognizer.Match(input, filePath)
+	if err != nil {
+		return
+	}
+	trees := mut.Recognizer.Forest(chart).Trees(1)
+	seed := MutatorSeed{
+		FilePath: filePath,
+		Input: input,
+	}
+	index := len(mut.Seeds)
+	var collect func(tree *ParseTree, depth int)
+	collect = func(tree *ParseTree, depth int) {
+		if tree.Nonterminal < 0 {
+			return
+		}
+		if mut.Recognizer.Nonterminals[tree.Nonterminal].IsRule {
+			node := SeedNode{
+				Seed: index,
+				Tree: tree,
+				Depth: depth,
+			}
+			seed.Nodes = append(seed.Nodes, node)
+			mut.Nodes[tree.Nonterminal] = append(mut.Nodes[tree.Nonterminal], node)
+			depth += 1
+		}
+		for _, child := range tree.Children {
+			collect(child, depth)
+		}
+	}
+	collect(trees[0], 0)
+	mut.Seeds = append(mut.Seeds, seed)
+	return
+}
+
+// Generates the next mutant. Every mutant has its own seed, so it can be regenerated on its own
+// with MutateWithSeed given the same seed inputs.
+func (mut *Mutator) Next() (message []rune, seed int64, err error) {
+	seed = MessageSeed(mut.Generator.Seed, mut.Count)
+	mut.Count += 1
+	message, err = mut.MutateWithSeed(seed)
+	return
+}
+
+func (mut *Mutator) MutateWithSeed(seed int64) (message []rune, err error) {
+	if len(mut.Seeds) == 0 {
+		err = fmt.Errorf("There are no seeds to mutate")
+		return
+	}
+	gen := mut.Generator
+	gen.Rand = rand.New(rand.NewSource(seed))
+	if gen.Heights == nil {
+		gen.Heights = MinHeights(gen.Grammar.Rules)
+	}
+
+	target := mut.Seeds[gen.Rand.Intn(len(mut.Seeds))]
+	node := target.Nodes[gen.Rand.Intn(len(target.Nodes))]
+	replacement := []rune{}
+	donors := mut.Nodes[node.Tree.Nonterminal]
+	if len(donors) > 1 && gen.Rand.Intn(2) == 0 {
+		donor := donors[gen.Rand.Intn(len(donors))]
+		replacement = mut.Seeds[donor.Seed].Input[donor.Tree.Start:donor.Tree.End]
+	} else {
+		rule := mut.Recognizer.Nonterminals[node.Tree.Nonterminal].Rule
+		maxSize := gen.MaxSize
+		gen.Size, gen.MaxSize = 0, mut.RegeneratedSize(target, node)
+		replacement, err = gen.GenerateRandomMessage(gen.Grammar.Rules[rule].Body, node.Depth + 1)
+		gen.MaxSize = maxSize
+		if err != nil {
+			err = WithSources(err, gen.Grammar.Sources)
+			return
+		}
+	}
+
+	message = append(message, target.Input[:node.Tree.Start]...)
+	message = append(message, replacement...)
+	message = append(message, target.Input[node.Tree.End:]...)
+	return
+}
@@ -0,0 +1,86 @@
+package bnf
+
+import (
+	"strings"
+	"testing"
+)
+
+func MustMutator(t *testing.T, grammar *Grammar, entry string, seeds ...string) (*Mutator, *Recognizer) {
+	t.Helper()
+	rec, err := NewRecognizer(grammar, entry)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	mut := NewMutator(NewGenerator(grammar, 1), rec)
+	for _, seed := range seeds {
+		if err := mut.AddSeed([]rune(seed), "seed"); err != nil {
+			t.Fatalf("%q: %s", seed, err)
+		}
+	}
+	return mut, rec
+}
+
+// Every mutant has to match the grammar, to be reproducible by its seed and to stay close to the seeds
+func TestMutantsMatch(t *testing.T) {
+	tests := []struct {
+		grammar string
+		entry string
+		seeds []string
+	}{
+		{"e = e \"+\" e / \"(\" e \")\" / 1*DIGIT\n", "e", []string{"(1+22)+333"}},
+		{"m = cmd *( SP arg ) CRLF\ncmd = %s\"GET\" / %s\"SET\"\narg = 1*ALPHA\n", "m", []string{"GET foo\r\n", "SET bar baz\r\n"}},
+		{"c = \"z\" / c c c\n", "c", []string{"zzz"}},
+	}
+	for _, test := range tests {
+		grammar := MustParse(t, test.grammar, "test.abnf")
+		mut, rec := MustMutator(t, grammar, test.entry, test.seeds...)
+		longest := 0
+		for _, seed := range test.seeds {
+			if len(seed) > longest {
+				longest = len(seed)
+			}
+		}
+		for i := 0; i < 500; i += 1 {
+			message, seed, err := mut.Next()
+			if err != nil {
+				t.Fatalf("%q: %s", test.grammar, err)
+			}
+			if !rec.Accepted(rec.Parse(message)) {
+				t.Fatalf("%q: the mutant %q of the seed %d does not match", test.grammar, string(message), seed)
+			}
+			// The replaced part grows at most DefaultMutationGrowth times plus the shortest way to finish it
+			if len(message) > longest*DefaultMutationGrowth + MinRegeneratedSize*4 {
+				t.Fatalf("%q: the mutant %q of the seed %d is too long", test.grammar, string(message), seed)
+			}
+			again, err := mut.MutateWithSeed(seed)
+			if err != nil || string(again) != string(message) {
+				t.Fatalf("%q: the seed %d regenerated %q instead of %q", test.grammar, seed, string(again), string(message))
+			}
+		}
+	}
+}
+
+// The spans of the seeds are only ever replaced with the spans of the same rule. Both a and b
+// consist of the same characters, so a splice of a span of a into b would still match. The words
+// differ in every character, so the splices of c can't turn one into another.
+func TestMutatorSplicesSameRule(t *testing.T) {
+	grammar := MustParse(t, "m = a \"-\" b\na = 1*c\nb = 1*c\nc = %x61-7A\n", "test.abnf")
+	mut, _ := MustMutator(t, grammar, "m", "foo-bar", "xyz-qux")
+	spliced := false
+	for i := 0; i < 2000; i += 1 {
+		message, seed, err := mut.Next()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		parts := strings.SplitN(string(message), "-", 2)
+		if parts[0] == "bar" || parts[0] == "qux" || parts[1] == "foo" || parts[1] == "xyz" {
+			t.Fatalf("the mutant %q of the seed %d spliced a span of another rule", string(message), seed)
+		}
+		if string(message) == "xyz-bar" || string(message) == "foo-qux" {
+			spliced = true
+		}
+	}
+	if !spliced {
+		t.Fatalf("expected the spans of a and b to be spliced between the seeds")
+	}
+}
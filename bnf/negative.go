@@ -0,0 +1,246 @@
+package bnf
+
+import (
+	"fmt"
+	"math/rand"
+	"unicode"
+)
+
+// The mutation operators of the negative generation
+const (
+	// Drop an element of a concatenation that can't be empty
+	OpDrop = "drop"
+	// Repeat one more time than the upper bound of the repetition allows
+	OpRepeatMore = "repeat-more"
+	// Repeat one less time than the lower bound of the repetition requires
+	OpRepeatLess = "repeat-less"
+	// Emit a character just outside of a range or a character set
+	OpRange = "range"
+	// Change, drop or duplicate a character of a string, or flip its case if it is case-sensitive
+	OpString = "string"
+)
+
+var NegativeOps = []string{OpDrop, OpRepeatMore, OpRepeatLess, OpRange, OpString}
+
+// Computes which rules can produce the empty message
+func NullableRules(grammar map[string]Rule) map[string]bool {
+	nullable := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for name, rule := range grammar {
+			if !nullable[name] && IsNullableExpr(nullable, rule.Body) {
+				nullable[name] = true
+				changed = true
+			}
+		}
+	}
+	return nullable
+}
+
+func IsNullableExpr(nullable map[string]bool, expr Expr) bool {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		return nullable[expr.Name]
+	case ExprString:
+		return len(expr.Text) == 0
+	case ExprConcat:
+		for _, element := range expr.Elements {
+			if !IsNullableExpr(nullable, element) {
+				return false
+			}
+		}
+		return true
+	case ExprAlternation:
+		for _, variant := range expr.Variants {
+			if IsNullableExpr(nullable, variant) {
+				return true
+			}
+		}
+		return false
+	case ExprRepetition:
+		return expr.Lower == 0 || IsNullableExpr(nullable, expr.Body)
+	}
+	return false
+}
+
+// Where and how the message was made invalid
+type NegativeLabel struct {
+	Operator string
+	// The rule the mutated expression belongs to
+	Rule string
+	// The mutated expression
+	Loc Loc
+}
+
+// Counts the sites the operators can be applied to while generating a message and applies the
+// Operator to the Target-th site of it
+type NegativeMutation struct {
+	// Empty to only count the Sites
+	Operator string
+	Target int
+	Sites map[string]int
+	// Set once the Operator is applied
+	Label *NegativeLabel
+}
+
+// Whether the operator has to be applied at this site of the message
+func (gen *Generator) NegativeSite(op string, loc Loc) bool {
+	mutation := gen.Negative
+	if mutation == nil || mutation.Label != nil {
+		return false
+	}
+	index := mutation.Sites[op]
+	mutation.Sites[op] += 1
+	if op != mutation.Operator || index != mutation.Target {
+		return false
+	}
+	mutation.Label = &NegativeLabel{
+		Operator: op,
+		Rule: gen.Rule,
+		Loc: loc,
+	}
+	return true
+}
+
+// Whether the character can be emitted at all
+func (gen *Generator) IsEmittable(x rune) bool {
+	if gen.Bytes {
+		return 0 <= x && x <= 0xFF
+	}
+	return MatchTerminal(CharUniverse, x)
+}
+
+// Picks a character right next to one of the bounds of the class that is not in the class
+func (gen *Generator) OutsideChar(class ExprCharClass) (x rune, ok bool) {
+	candidates := []rune{}
+	for _, r := range class.Ranges {
+		for _, y := range []rune{r.Lower - 1, r.Upper + 1} {
+			if gen.IsEmittable(y) && !MatchTerminal(class, y) {
+				candidates = append(candidates, y)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	return candidates[gen.Rand.Intn(len(candidates))], true
+}
+
+// Whether OutsideChar can find a character without touching the Rand
+func (gen *Generator) HasOutsideChar(class ExprCharClass) bool {
+	for _, r := range class.Ranges {
+		for _, y := range []rune{r.Lower - 1, r.Upper + 1} {
+			if gen.IsEmittable(y) && !MatchTerminal(class, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (gen *Generator) NearMiss(text []rune, caseInsensitive bool) (result []rune) {
+	result = append(result, text...)
+	i := gen.Rand.Intn(len(result))
+	x := result[i]
+	if !caseInsensitive && unicode.ToUpper(x) != unicode.ToLower(x) && gen.Rand.Intn(2) == 0 {
+		if unicode.IsUpper(x) {
+			result[i] = unicode.ToLower(x)
+		} else {
+			result[i] = unicode.ToUpper(x)
+		}
+		return
+	}
+	switch gen.Rand.Intn(3) {
+	case 0:
+		for _, y := range []rune{x + 1, x - 1} {
+			if gen.IsEmittable(y) {
+				result[i] = y
+				break
+			}
+		}
+	case 1:
+		result = append(result[:i], result[i + 1:]...)
+	default:
+		result = append(result[:i + 1], result[i:]...)
+	}
+	return
+}
+
+const MaxNegativeAttempts = 100
+
+// Generates the messages that almost match the grammar: every message is generated with exactly one
+// of the Operators applied once. The messages that still match the entry are thrown away if there is
+// a Recognizer.
+type NegativeGenerator struct {
+	Generator *Generator
+	// Compiled for the same entry as the messages are generated from. nil means no confirmation.
+	Recognizer *Recognizer
+	Operators []string
+	// How many seeds were tried by Next so far
+	Count int
+}
+
+// Generates the next invalid message trying up to MaxNegativeAttempts seeds
+func (neg *NegativeGenerator) Next(entry string) (message []rune, seed int64, label *NegativeLabel, err error) {
+	for attempt := 0; attempt < MaxNegativeAttempts; attempt += 1 {
+		seed = MessageSeed(neg.Generator.Seed, neg.Count)
+		neg.Count += 1
+		message, label, err = neg.GenerateWithSeed(entry, seed)
+		if err != nil || label != nil {
+			return
+		}
+	}
+	err = fmt.Errorf("Could not generate an invalid message from %s in %d attempts", entry, MaxNegativeAttempts)
+	return
+}
+
+// The label is nil if none of the Operators can be applied to the message of the seed or the
+// Recognizer still accepts the mutated message
+func (neg *NegativeGenerator) GenerateWithSeed(entry string, seed int64) (message []rune, label *NegativeLabel, err error) {
+	gen := neg.Generator
+	defer func() {
+		gen.Negative = nil
+	}()
+
+	gen.Negative = &NegativeMutation{
+		Target: -1,
+		Sites: map[string]int{},
+	}
+	if _, err = gen.GenerateWithSeed(entry, seed); err != nil {
+		return
+	}
+	sites := gen.Negative.Sites
+	total := 0
+	for _, op := range neg.Operators {
+		total += sites[op]
+	}
+	if total == 0 {
+		return
+	}
+
+	// Every site of every operator is equally likely
+	k := rand.New(rand.NewSource(MessageSeed(seed, 0))).Intn(total)
+	mutation := &NegativeMutation{
+		Sites: map[string]int{},
+	}
+	for _, op := range neg.Operators {
+		if k < sites[op] {
+			mutation.Operator = op
+			mutation.Target = k
+			break
+		}
+		k -= sites[op]
+	}
+	gen.Negative = mutation
+	if message, err = gen.GenerateWithSeed(entry, seed); err != nil {
+		return
+	}
+	if mutation.Label == nil {
+		return
+	}
+	if neg.Recognizer != nil && neg.Recognizer.Accepted(neg.Recognizer.Parse(message)) {
+		return
+	}
+	label = mutation.Label
+	return
+}
@@ -0,0 +1,114 @@
+package bnf
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Every grammar has the sites of the operator only in the rule and on the zero-based row of the test
+func TestNegativeOperators(t *testing.T) {
+	tests := []struct {
+		operator string
+		grammar string
+		rule string
+		row int
+	}{
+		{OpDrop, "m = a b\na = \"x\"\nb = \"y\"\n", "m", 0},
+		{OpRepeatMore, "m = \"<\" r \">\"\nr = 2*3\"x\"\n", "r", 1},
+		{OpRepeatLess, "m = \"<\" r \">\"\nr = 2*3\"x\"\n", "r", 1},
+		{OpRange, "m = \"<\" d \">\"\nd = %x30-39\n", "d", 1},
+		{OpRange, "m = \"<\" d \">\"\nd = \"a\" / \"c\" / %x30-39\n", "d", 1},
+		{OpString, "m = k\nk = %s\"abc\"\n", "k", 1},
+		{OpString, "m = k\nk = \"abc\"\n", "k", 1},
+	}
+	for _, test := range tests {
+		grammar := MustParse(t, test.grammar, "test.abnf")
+		rec, err := NewRecognizer(grammar, "m")
+		if err != nil {
+			t.Fatalf("%s %q: %s", test.operator, test.grammar, err)
+		}
+		neg := &NegativeGenerator{
+			Generator: NewGenerator(grammar, 1),
+			Recognizer: rec,
+			Operators: []string{test.operator},
+		}
+		for i := 0; i < 50; i += 1 {
+			message, seed, label, err := neg.Next("m")
+			if err != nil {
+				t.Fatalf("%s %q: %s", test.operator, test.grammar, err)
+			}
+			if rec.Accepted(rec.Parse(message)) {
+				t.Fatalf("%s %q: the message %q of the seed %d matches", test.operator, test.grammar, string(message), seed)
+			}
+			if label.Operator != test.operator || label.Rule != test.rule || label.Loc.Row != test.row {
+				t.Fatalf("%s %q: expected the label %s in %s on the row %d, got %s in %s at %s", test.operator, test.grammar, test.operator, test.rule, test.row + 1, label.Operator, label.Rule, label.Loc)
+			}
+			again, againLabel, err := neg.GenerateWithSeed("m", seed)
+			if err != nil || againLabel == nil || string(again) != string(message) || *againLabel != *label {
+				t.Fatalf("%s %q: the seed %d regenerated %q instead of %q", test.operator, test.grammar, seed, string(again), string(message))
+			}
+		}
+	}
+}
+
+func TestNegativeWithoutSites(t *testing.T) {
+	grammar := MustParse(t, "m = \"x\"\n", "test.abnf")
+	neg := &NegativeGenerator{
+		Generator: NewGenerator(grammar, 1),
+		Operators: []string{OpDrop, OpRepeatMore},
+	}
+	if _, _, _, err := neg.Next("m"); err == nil {
+		t.Fatalf("expected an error when none of the operators can be applied")
+	}
+	if neg.Count != MaxNegativeAttempts {
+		t.Fatalf("expected %d attempts, got %d", MaxNegativeAttempts, neg.Count)
+	}
+}
+
+func TestOutsideChar(t *testing.T) {
+	tests := []struct {
+		class ExprCharClass
+		bytes bool
+		outside []rune
+	}{
+		{NewCharClass(Loc{}, []ExprRange{{Lower: '0', Upper: '9'}}), false, []rune{'/', ':'}},
+		{NewCharClass(Loc{}, []ExprRange{{Lower: 0, Upper: 'a'}}), false, []rune{'b'}},
+		{NewCharClass(Loc{}, []ExprRange{{Lower: 0, Upper: 0xFF}}), false, []rune{0x100}},
+		{NewCharClass(Loc{}, []ExprRange{{Lower: 0, Upper: 0xFF}}), true, nil},
+	}
+	for _, test := range tests {
+		gen := NewGenerator(&Grammar{}, 1)
+		gen.Bytes = test.bytes
+		gen.Rand = rand.New(rand.NewSource(1))
+		if has := gen.HasOutsideChar(test.class); has != (len(test.outside) > 0) {
+			t.Errorf("%s: expected HasOutsideChar %t, got %t", test.class, len(test.outside) > 0, has)
+		}
+		for i := 0; i < 20; i += 1 {
+			x, ok := gen.OutsideChar(test.class)
+			if ok != (len(test.outside) > 0) {
+				t.Fatalf("%s: expected %t, got %t", test.class, len(test.outside) > 0, ok)
+			}
+			if ok && !strings.ContainsRune(string(test.outside), x) {
+				t.Fatalf("%s: expected one of %q, got %q", test.class, string(test.outside), x)
+			}
+		}
+	}
+}
+
+func TestNearMiss(t *testing.T) {
+	gen := NewGenerator(&Grammar{}, 1)
+	for seed := int64(0); seed < 200; seed += 1 {
+		gen.Rand = rand.New(rand.NewSource(seed))
+		text := "abc"
+		for _, caseInsensitive := range []bool{false, true} {
+			result := string(gen.NearMiss([]rune(text), caseInsensitive))
+			if result == text || (caseInsensitive && strings.EqualFold(result, text)) {
+				t.Fatalf("seed %d: %q is not a miss of %q (case-insensitive %t)", seed, result, text, caseInsensitive)
+			}
+			if len(result) < len(text) - 1 || len(result) > len(text) + 1 {
+				t.Fatalf("seed %d: %q is too far from %q", seed, result, text)
+			}
+		}
+	}
+}
@@ -0,0 +1,915 @@
+package bnf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type Expr interface {
+	GetLoc() Loc
+	String() string
+}
+
+type ExprSymbol struct {
+	Loc Loc
+	Name string
+	// Namespace of the file the symbol is referred from. See ResolveNamespaces.
+	Namespace string
+}
+
+func (expr ExprSymbol) GetLoc() Loc {
+	return expr.Loc
+}
+
+func (expr ExprSymbol) String() string {
+	return expr.Name
+}
+
+type ExprString struct {
+	Loc Loc
+	Text []rune
+	CaseInsensitive bool
+}
+
+func (expr ExprString) GetLoc() Loc {
+	return expr.Loc
+}
+
+func (expr ExprString) String() string {
+	sb := strings.Builder{}
+	for i := range expr.Text {
+		if !unicode.IsGraphic(expr.Text[i]) && expr.Text[i] > 0xFF {
+			// String literals can't escape values wider than a byte, so the whole thing goes as a numeric value
+			sb.WriteString("%x")
+			for j := range expr.Text {
+				if j > 0 {
+					sb.WriteRune('.')
+				}
+				sb.WriteString(fmt.Sprintf("%02X", expr.Text[j]))
+			}
+			return sb.String()
+		}
+	}
+	if expr.CaseInsensitive && strings.ToUpper(string(expr.Text)) != strings.ToLower(string(expr.Text)) {
+		sb.WriteString("%i")
+	}
+	sb.WriteRune('"')
+	for i := range expr.Text {
+		switch expr.Text[i] {
+		case '\n': sb.WriteString("\\n")
+		case '\r': sb.WriteString("\\r")
+		case '\\': sb.WriteString("\\\\")
+		case '"':  sb.WriteString("\\\"")
+		default:
+			if (unicode.IsGraphic(expr.Text[i])) {
+				sb.WriteRune(expr.Text[i])
+			} else {
+				sb.WriteString(fmt.Sprintf("\\x%02x", expr.Text[i]))
+			}
+		}
+	}
+	sb.WriteRune('"')
+	return sb.String()
+}
+
+type ExprAlternation struct {
+	Loc Loc
+	Variants []Expr
+	// The weights of the Variants annotated like `@10 "x" | "y"`. nil means they are picked uniformly.
+	Weights []uint
+	// The Variants as a set of characters if all of them are single characters, see WithCharClass
+	Class *ExprCharClass
+}
+
+func (expr ExprAlternation) GetLoc() Loc {
+	return expr.Loc
+}
+
+// The separator of the alternatives the expressions are rendered with
+func AlternationSeparator() string {
+	for i := range SyntaxMixed.LiteralTokens {
+		if SyntaxMixed.LiteralTokens[i].Kind == TokenAlternation {
+			return SyntaxMixed.LiteralTokens[i].Text
+		}
+	}
+	// This should be possible to check at compile time in 2023
+	panic("Not a single TokenAlternation exists to render ExprAlternation")
+}
+
+func (expr ExprAlternation) String() string {
+	sep := AlternationSeparator()
+	sb := strings.Builder{}
+	for i := range expr.Variants {
+		if i > 0 {
+			sb.WriteString(" "+sep+" ")
+		}
+		if expr.Weights != nil {
+			sb.WriteString(fmt.Sprintf("@%d ", expr.Weights[i]))
+		}
+		sb.WriteString(expr.Variants[i].String())
+	}
+	return sb.String()
+}
+
+// The alternatives of both expressions as one alternation keeping their weights. The unannotated
+// alternatives get the weight of 1.
+func JoinAlternatives(a Expr, b Expr) (alt ExprAlternation) {
+	alt.Loc = a.GetLoc()
+	for _, expr := range []Expr{a, b} {
+		if other, ok := expr.(ExprAlternation); ok {
+			if other.Weights != nil && alt.Weights == nil {
+				alt.Weights = []uint{}
+				for range alt.Variants {
+					alt.Weights = append(alt.Weights, 1)
+				}
+			}
+			for i := range other.Variants {
+				alt.Variants = append(alt.Variants, other.Variants[i])
+				if other.Weights != nil {
+					alt.Weights = append(alt.Weights, other.Weights[i])
+				} else if alt.Weights != nil {
+					alt.Weights = append(alt.Weights, 1)
+				}
+			}
+		} else {
+			alt.Variants = append(alt.Variants, expr)
+			if alt.Weights != nil {
+				alt.Weights = append(alt.Weights, 1)
+			}
+		}
+	}
+	return
+}
+
+type ExprConcat struct {
+	Loc Loc
+	Elements []Expr
+}
+
+func (expr ExprConcat) GetLoc() Loc {
+	return expr.Loc
+}
+
+func (expr ExprConcat) String() string {
+	sb := strings.Builder{}
+	for i := range expr.Elements {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		switch expr.Elements[i].(type) {
+		case ExprAlternation:
+			sb.WriteString("( "+expr.Elements[i].String()+" )")
+		case ExprCharClass:
+			if len(expr.Elements[i].(ExprCharClass).Ranges) > 1 {
+				sb.WriteString("( "+expr.Elements[i].String()+" )")
+			} else {
+				sb.WriteString(expr.Elements[i].String())
+			}
+		default:
+			sb.WriteString(expr.Elements[i].String())
+		}
+	}
+	return sb.String()
+}
+
+type ExprRepetition struct {
+	Loc Loc
+	Body Expr
+	Lower uint
+	Upper uint
+	// The upper bound is not specified in the grammar. The Upper is set by Options.MaxRepetitions
+	// or the max-repetitions of the -profile then.
+	Unbounded bool
+	// How the amount of repetitions is picked between the bounds. nil means uniformly.
+	Distribution *Distribution
+}
+
+func (expr ExprRepetition) GetLoc() Loc {
+	return expr.Loc
+}
+
+func (expr ExprRepetition) String() string {
+	if expr.Unbounded {
+		if expr.Lower == 0 {
+			return fmt.Sprintf("*( %s )", expr.Body.String())
+		}
+		return fmt.Sprintf("%d*( %s )", expr.Lower, expr.Body.String())
+	}
+	if expr.Lower == 0 && expr.Upper == 1 {
+		return fmt.Sprintf("[ %s ]", expr.Body.String())
+	}
+	if expr.Lower == expr.Upper {
+		return fmt.Sprintf("%d( %s )", expr.Lower, expr.Body.String())
+	}
+	return fmt.Sprintf("%d*%d( %s )", expr.Lower, expr.Upper, expr.Body.String())
+}
+
+type ExprRange struct {
+	Loc Loc
+	Lower rune
+	Upper rune
+}
+
+func (expr ExprRange) GetLoc() Loc {
+	return expr.Loc
+}
+
+func (expr ExprRange) String() string {
+	return fmt.Sprintf("%%x%02X-%02X", expr.Lower, expr.Upper)
+}
+
+// Code points that can be generated. Everything except the surrogates.
+var CharUniverse = ExprCharClass{
+	Ranges: []ExprRange{
+		{ Lower: 0, Upper: 0xD7FF },
+		{ Lower: 0xE000, Upper: unicode.MaxRune },
+	},
+}
+
+// A set of code points. Ranges are always sorted and merged.
+type ExprCharClass struct {
+	Loc Loc
+	Ranges []ExprRange
+}
+
+func NewCharClass(loc Loc, ranges []ExprRange) ExprCharClass {
+	sorted := append([]ExprRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Lower < sorted[j].Lower
+	})
+	class := ExprCharClass{
+		Loc: loc,
+	}
+	for _, r := range sorted {
+		n := len(class.Ranges)
+		if n > 0 && r.Lower <= class.Ranges[n-1].Upper + 1 {
+			if r.Upper > class.Ranges[n-1].Upper {
+				class.Ranges[n-1].Upper = r.Upper
+			}
+			continue
+		}
+		class.Ranges = append(class.Ranges, r)
+	}
+	return class
+}
+
+func (expr ExprCharClass) GetLoc() Loc {
+	return expr.Loc
+}
+
+// Rendered as the alternation of the numeric values like ExprAlternation, so it can be read back
+func (expr ExprCharClass) String() string {
+	sep := AlternationSeparator()
+	sb := strings.Builder{}
+	for i, r := range expr.Ranges {
+		if i > 0 {
+			sb.WriteString(" "+sep+" ")
+		}
+		if r.Lower == r.Upper {
+			sb.WriteString(fmt.Sprintf("%%x%02X", r.Lower))
+		} else {
+			sb.WriteString(r.String())
+		}
+	}
+	return sb.String()
+}
+
+func (expr ExprCharClass) Union(other ExprCharClass) ExprCharClass {
+	return NewCharClass(expr.Loc, append(append([]ExprRange{}, expr.Ranges...), other.Ranges...))
+}
+
+func (expr ExprCharClass) Subtract(other ExprCharClass) ExprCharClass {
+	result := ExprCharClass{
+		Loc: expr.Loc,
+	}
+	i := 0
+	for _, r := range expr.Ranges {
+		for i < len(other.Ranges) && other.Ranges[i].Upper < r.Lower {
+			i += 1
+		}
+		for j := i; j < len(other.Ranges) && other.Ranges[j].Lower <= r.Upper; j += 1 {
+			if r.Lower < other.Ranges[j].Lower {
+				result.Ranges = append(result.Ranges, ExprRange{Loc: r.Loc, Lower: r.Lower, Upper: other.Ranges[j].Lower - 1})
+			}
+			r.Lower = other.Ranges[j].Upper + 1
+		}
+		if r.Lower <= r.Upper {
+			result.Ranges = append(result.Ranges, r)
+		}
+	}
+	return result
+}
+
+func (expr ExprCharClass) Negate() ExprCharClass {
+	result := CharUniverse.Subtract(expr)
+	result.Loc = expr.Loc
+	return result
+}
+
+// The amount of characters in the class
+func (expr ExprCharClass) Size() (size int64) {
+	for _, r := range expr.Ranges {
+		size += int64(r.Upper - r.Lower + 1)
+	}
+	return
+}
+
+// The k-th character of the class in the ascending order
+func (expr ExprCharClass) Nth(k int64) rune {
+	for _, r := range expr.Ranges {
+		size := int64(r.Upper - r.Lower + 1)
+		if k < size {
+			return r.Lower + rune(k)
+		}
+		k -= size
+	}
+	panic("Character index is out of bounds of the class")
+}
+
+// ISO 14977 and W3C exception `a - b`. Lowered into ExprCharClass by LowerExceptions after the whole grammar is parsed,
+// because the operands may refer to the symbols that are not defined yet. The exceptions between anything other than
+// sets of single characters stay in the grammar and are reported by Grammar.VerifyExceptions.
+type ExprException struct {
+	Loc Loc
+	Body Expr
+	Except Expr
+}
+
+func (expr ExprException) GetLoc() Loc {
+	return expr.Loc
+}
+
+func (expr ExprException) String() string {
+	return fmt.Sprintf("( %s - %s )", expr.Body.String(), expr.Except.String())
+}
+
+func ExpectToken(lexer *Lexer, kind TokenKind) (token Token, err error) {
+	token, err = lexer.Next()
+	if err != nil {
+		return
+	}
+	if token.Kind != kind {
+		err = &DiagErr{
+			Loc: token.Loc,
+			Len: len(token.Text),
+			Err: fmt.Errorf("Expected %s but got %s", TokenKindName[kind], TokenKindName[token.Kind]),
+		}
+		return
+	}
+	return
+}
+
+func ExpectRuleEnd(lexer *Lexer) (err error) {
+	var end Token
+	end, err = lexer.Next()
+	if err == nil && end.Kind != TokenEOL && end.Kind != TokenEOF && end.Kind != TokenTerminator {
+		expected := TokenEOL
+		if lexer.Syntax.Terminated {
+			expected = TokenTerminator
+		}
+		err = &DiagErr{
+			Loc: end.Loc,
+			Len: len(end.Text),
+			Err: fmt.Errorf("Expected %s but got %s", TokenKindName[expected], TokenKindName[end.Kind]),
+		}
+	}
+	return
+}
+
+// The default upper bound of the repetitions like `*a` or `{a}`, see Options.MaxRepetitions
+const MaxUnspecifiedUpperRepetitionBound = 20
+
+func ParsePrimaryExpr(lexer *Lexer) (expr Expr, err error) {
+	var token Token
+	token, err = lexer.Next()
+	if err != nil {
+		return
+	}
+	switch token.Kind {
+	case TokenParenOpen:
+		expr, err = ParseExpr(lexer)
+		if err != nil {
+			return
+		}
+		_, err = ExpectToken(lexer, TokenParenClose)
+		if err != nil {
+			return
+		}
+	case TokenCurlyOpen:
+		var body Expr
+		body, err = ParseExpr(lexer)
+		if err != nil {
+			return
+		}
+		_, err = ExpectToken(lexer, TokenCurlyClose)
+		if err != nil {
+			return
+		}
+		expr = ExprRepetition{
+			Loc: token.Loc,
+			Body: body,
+			Lower: 0,
+			Upper: MaxUnspecifiedUpperRepetitionBound,
+			Unbounded: true,
+		}
+	case TokenBracketOpen:
+		var body Expr
+		body, err = ParseExpr(lexer)
+		if err != nil {
+			return
+		}
+		_, err = ExpectToken(lexer, TokenBracketClose)
+		if err != nil {
+			return
+		}
+		expr = ExprRepetition{
+			Loc: token.Loc,
+			Body: body,
+			Lower: 0,
+			Upper: 1,
+		}
+	case TokenSymbol:
+		expr = ExprSymbol{
+			Loc:  token.Loc,
+			Name: string(token.Text),
+			Namespace: lexer.Namespace,
+		}
+	case TokenCharClass:
+		if len(token.Text) == 0 {
+			err = &DiagErr{
+				Loc: token.Loc,
+				Err: fmt.Errorf("Character class is empty"),
+			}
+			return
+		}
+
+		ranges := []ExprRange{}
+		for i := 0; i+1 < len(token.Text); i += 2 {
+			if token.Text[i] > token.Text[i+1] {
+				err = &DiagErr{
+					Loc: token.Loc,
+					Err: fmt.Errorf("Upper bound of the range is lower than the lower one."),
+				}
+				return
+			}
+			ranges = append(ranges, ExprRange{
+				Loc: token.Loc,
+				Lower: token.Text[i],
+				Upper: token.Text[i+1],
+			})
+		}
+		class := NewCharClass(token.Loc, ranges)
+		if token.Negated {
+			class = class.Negate()
+		}
+		expr = class
+	case TokenValueRange:
+		if len(token.Text) != 2 {
+			err = &DiagErr{
+				Loc: token.Loc,
+				Err: fmt.Errorf("Value range is expected to have 2 bounds but got %d", len(token.Text)),
+			}
+			return
+		}
+
+		expr = ExprRange{
+			Loc: token.Loc,
+			Lower: token.Text[0],
+			Upper: token.Text[1],
+		}
+
+		return
+	case TokenString:
+		var ellipsis Token
+		ellipsis, err = lexer.Peek()
+		if err != nil {
+			return
+		}
+		if ellipsis.Kind != TokenEllipsis {
+			expr = ExprString{
+				Loc: token.Loc,
+				Text: token.Text,
+				CaseInsensitive: token.CaseInsensitive,
+			}
+			return
+		}
+
+		if len(token.Text) != 1 {
+			err = &DiagErr{
+				Loc: token.Loc,
+				Err: fmt.Errorf("The lower boundary of the range is expected to be 1 symbol string. Got %d instead.", len(token.Text)),
+			}
+			return
+		}
+
+		lexer.PeekFull = false
+		var upper Token
+
+		upper, err = ExpectToken(lexer, TokenString)
+		if err != nil {
+			return
+		}
+
+		if len(upper.Text) != 1 {
+			err = &DiagErr{
+				Loc: upper.Loc,
+				Err: fmt.Errorf("The upper boundary of the range is expected to be 1 symbol string. Got %d instead.", len(upper.Text)),
+			}
+			return
+		}
+
+		expr = ExprRange{
+			Loc: ellipsis.Loc,
+			Lower: token.Text[0],
+			Upper: upper.Text[0],
+		}
+
+	case TokenAsterisk:
+		var upper Token
+		upper, err = lexer.Peek()
+		if err != nil {
+			return
+		}
+
+		var body Expr
+		if upper.Kind != TokenNumber {
+			body, err = ParsePrimaryExpr(lexer)
+			if err != nil {
+				return
+			}
+			expr = ExprRepetition{
+				Loc: token.Loc,
+				Lower: 0,
+				Upper: MaxUnspecifiedUpperRepetitionBound,
+				Unbounded: true,
+				Body: body,
+			}
+			return
+		}
+		lexer.PeekFull = false
+
+		body, err = ParsePrimaryExpr(lexer)
+		if err != nil {
+			return
+		}
+
+		expr = ExprRepetition{
+			Loc: token.Loc,
+			Lower: 0,
+			Upper: upper.Number,
+			Body: body,
+		}
+		return
+
+	case TokenNumber:
+		var asterisk Token
+		asterisk, err = lexer.Peek()
+		if err != nil {
+			return
+		}
+
+		var body Expr
+
+		if asterisk.Kind == TokenRepetition {
+			lexer.PeekFull = false
+			body, err = ParsePrimaryExpr(lexer)
+			if err != nil {
+				return
+			}
+			expr = ExprRepetition{
+				Loc: token.Loc,
+				Lower: token.Number,
+				Upper: token.Number,
+				Body: body,
+			}
+			return
+		}
+
+		if asterisk.Kind != TokenAsterisk {
+			body, err = ParsePrimaryExpr(lexer)
+			if err != nil {
+				return
+			}
+			expr = ExprRepetition{
+				Loc: token.Loc,
+				Lower: token.Number,
+				Upper: token.Number,
+				Body: body,
+			}
+			return
+		}
+		lexer.PeekFull = false
+
+		var upper Token
+		upper, err = lexer.Peek()
+
+		if upper.Kind != TokenNumber {
+			body, err = ParsePrimaryExpr(lexer)
+			if err != nil {
+				return
+			}
+			expr = ExprRepetition{
+				Loc: asterisk.Loc,
+				Lower: token.Number,
+				Upper: MaxUnspecifiedUpperRepetitionBound,
+				Unbounded: true,
+				Body: body,
+			}
+			return
+		}
+		lexer.PeekFull = false
+
+		body, err = ParsePrimaryExpr(lexer)
+		if err != nil {
+			return
+		}
+		expr = ExprRepetition{
+			Loc: asterisk.Loc,
+			Lower: token.Number,
+			Upper: upper.Number,
+			Body: body,
+		}
+		return
+	default:
+		err = &DiagErr{
+			Loc: token.Loc,
+			Err: fmt.Errorf("Expected start of an expression, but got %s", TokenKindName[token.Kind]),
+		}
+	}
+	return
+}
+
+func IsPrimaryStart(kind TokenKind) bool {
+	return kind == TokenSymbol ||
+		kind == TokenString ||
+		kind == TokenBracketOpen ||
+		kind == TokenCurlyOpen ||
+		kind == TokenParenOpen ||
+		kind == TokenNumber ||
+		kind == TokenAsterisk ||
+		kind == TokenValueRange ||
+		kind == TokenCharClass
+}
+
+// RFC 5234 strings are case-insensitive only for the US-ASCII letters
+func ToUpperASCII(x rune) rune {
+	if 'a' <= x && x <= 'z' {
+		return x - 'a' + 'A'
+	}
+	return x
+}
+
+func ToLowerASCII(x rune) rune {
+	if 'A' <= x && x <= 'Z' {
+		return x - 'A' + 'a'
+	}
+	return x
+}
+
+// Represents the expression as a set of characters if it consists only of single characters.
+// The symbols are resolved only if grammar is provided.
+func CharClassOfExpr(grammar map[string]Rule, expr Expr, visited map[string]bool) (class ExprCharClass, ok bool) {
+	class.Loc = expr.GetLoc()
+	switch expr := expr.(type) {
+	case ExprCharClass:
+		class = expr
+		ok = true
+	case ExprRange:
+		class = NewCharClass(expr.Loc, []ExprRange{expr})
+		ok = true
+	case ExprString:
+		if len(expr.Text) != 1 {
+			return
+		}
+		x := expr.Text[0]
+		ranges := []ExprRange{{Loc: expr.Loc, Lower: x, Upper: x}}
+		if expr.CaseInsensitive {
+			ranges = append(ranges,
+				ExprRange{Loc: expr.Loc, Lower: ToUpperASCII(x), Upper: ToUpperASCII(x)},
+				ExprRange{Loc: expr.Loc, Lower: ToLowerASCII(x), Upper: ToLowerASCII(x)})
+		}
+		class = NewCharClass(expr.Loc, ranges)
+		ok = true
+	case ExprAlternation:
+		for i := range expr.Variants {
+			var variant ExprCharClass
+			variant, ok = CharClassOfExpr(grammar, expr.Variants[i], visited)
+			if !ok {
+				return
+			}
+			class = class.Union(variant)
+		}
+	case ExprException:
+		var except ExprCharClass
+		class, ok = CharClassOfExpr(grammar, expr.Body, visited)
+		if !ok {
+			return
+		}
+		except, ok = CharClassOfExpr(grammar, expr.Except, visited)
+		if !ok {
+			return
+		}
+		class = class.Subtract(except)
+	case ExprSymbol:
+		rule, exists := grammar[expr.Name]
+		if !exists || visited[expr.Name] {
+			return
+		}
+		visited[expr.Name] = true
+		class, ok = CharClassOfExpr(grammar, rule.Body, visited)
+		delete(visited, expr.Name)
+	}
+	class.Loc = expr.GetLoc()
+	return
+}
+
+// Caches the Class of the alternation of single characters, so the generator picks the characters
+// uniformly instead of picking an alternative first. The alternation itself is kept for the profiles
+// and the parse trees.
+func WithCharClass(alt ExprAlternation) ExprAlternation {
+	alt.Class = nil
+	if class, ok := CharClassOfExpr(nil, alt, nil); ok {
+		alt.Class = &class
+	}
+	return alt
+}
+
+// W3C postfix repetitions `a?`, `a+` and `a*`
+func ParsePostfixExpr(lexer *Lexer) (expr Expr, err error) {
+	expr, err = ParsePrimaryExpr(lexer)
+	if err != nil {
+		return
+	}
+
+	for {
+		var token Token
+		token, err = lexer.Peek()
+		if err != nil {
+			return
+		}
+
+		repetition := ExprRepetition{
+			Loc: token.Loc,
+			Body: expr,
+		}
+		switch token.Kind {
+		case TokenOptional:
+			repetition.Lower = 0
+			repetition.Upper = 1
+		case TokenOneOrMore:
+			repetition.Lower = 1
+			repetition.Upper = MaxUnspecifiedUpperRepetitionBound
+			repetition.Unbounded = true
+		case TokenZeroOrMore:
+			repetition.Lower = 0
+			repetition.Upper = MaxUnspecifiedUpperRepetitionBound
+			repetition.Unbounded = true
+		default:
+			return
+		}
+		lexer.PeekFull = false
+		expr = repetition
+	}
+}
+
+func ParseTermExpr(lexer *Lexer) (expr Expr, err error) {
+	expr, err = ParsePostfixExpr(lexer)
+	if err != nil {
+		return
+	}
+
+	var token Token
+	token, err = lexer.Peek()
+	if err != nil || token.Kind != TokenException {
+		return
+	}
+	lexer.PeekFull = false
+
+	var except Expr
+	except, err = ParsePostfixExpr(lexer)
+	if err != nil {
+		return
+	}
+
+	expr = ExprException{
+		Loc: token.Loc,
+		Body: expr,
+		Except: except,
+	}
+	return
+}
+
+func ParseConcatExpr(lexer *Lexer) (expr Expr, err error) {
+	var term Expr
+	term, err = ParseTermExpr(lexer)
+	if err != nil {
+		return
+	}
+
+	concat := ExprConcat{
+		Loc:      term.GetLoc(),
+		Elements: []Expr{term},
+	}
+
+	for {
+		var token Token
+		token, err = lexer.Peek()
+		if err != nil {
+			return
+		}
+		if token.Kind == TokenConcatenation {
+			lexer.PeekFull = false
+		} else if !IsPrimaryStart(token.Kind) {
+			break
+		}
+
+		var child Expr
+		child, err = ParseTermExpr(lexer)
+		if err != nil {
+			return
+		}
+		concat.Elements = append(concat.Elements, child)
+	}
+
+	if len(concat.Elements) == 1 {
+		expr = term
+	} else {
+		expr = concat
+	}
+	return
+}
+
+// An alternative with an optional weight like `@10 "x"`
+func ParseWeightedConcatExpr(lexer *Lexer) (expr Expr, weight uint, weighted bool, err error) {
+	var token Token
+	token, err = lexer.Peek()
+	if err != nil {
+		return
+	}
+	weight = 1
+	if token.Kind == TokenWeight {
+		lexer.PeekFull = false
+		weight = token.Number
+		weighted = true
+	}
+	expr, err = ParseConcatExpr(lexer)
+	return
+}
+
+func ParseAltExpr(lexer *Lexer) (expr Expr, err error) {
+	var concat Expr
+	var weight uint
+	var weighted bool
+	concat, weight, weighted, err = ParseWeightedConcatExpr(lexer)
+	if err != nil {
+		return
+	}
+
+	alt := ExprAlternation{
+		Loc:      concat.GetLoc(),
+		Variants: []Expr{concat},
+	}
+	weights := []uint{weight}
+	anyWeighted := weighted
+
+	var token Token
+	token, err = lexer.Peek()
+	if err != nil {
+		return
+	}
+	for err == nil && token.Kind == TokenAlternation {
+		token, err = ExpectToken(lexer, TokenAlternation)
+		if err != nil {
+			return
+		}
+		var child Expr
+		child, weight, weighted, err = ParseWeightedConcatExpr(lexer)
+		if err != nil {
+			return
+		}
+		alt.Variants = append(alt.Variants, child)
+		weights = append(weights, weight)
+		anyWeighted = anyWeighted || weighted
+		token, err = lexer.Peek()
+	}
+
+	if anyWeighted {
+		// A single weighted alternative is kept as an alternation, so =/ can join it with the weight
+		alt.Weights = weights
+		expr = alt
+		return
+	}
+	if len(alt.Variants) == 1 {
+		expr = concat
+		return
+	}
+	expr = WithCharClass(alt)
+	return
+}
+
+func ParseExpr(lexer *Lexer) (expr Expr, err error) {
+	expr, err = ParseAltExpr(lexer)
+	return
+}
@@ -0,0 +1,82 @@
+package bnf
+
+import (
+	"testing"
+	"unicode"
+)
+
+func Class(bounds ...rune) ExprCharClass {
+	ranges := []ExprRange{}
+	for i := 0; i + 1 < len(bounds); i += 2 {
+		ranges = append(ranges, ExprRange{Lower: bounds[i], Upper: bounds[i + 1]})
+	}
+	return NewCharClass(Loc{}, ranges)
+}
+
+func SameRanges(a ExprCharClass, b ExprCharClass) bool {
+	if len(a.Ranges) != len(b.Ranges) {
+		return false
+	}
+	for i := range a.Ranges {
+		if a.Ranges[i].Lower != b.Ranges[i].Lower || a.Ranges[i].Upper != b.Ranges[i].Upper {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCharClass(t *testing.T) {
+	tests := []struct {
+		name string
+		got ExprCharClass
+		want ExprCharClass
+	}{
+		{"merge overlapping", Class('c', 'f', 'a', 'd'), Class('a', 'f')},
+		{"merge adjacent", Class('a', 'c', 'd', 'f'), Class('a', 'f')},
+		{"union", Class('a', 'c').Union(Class('x', 'z')), Class('a', 'c', 'x', 'z')},
+		{"subtract middle", Class('a', 'z').Subtract(Class('m', 'm')), Class('a', 'l', 'n', 'z')},
+		{"subtract several", Class('a', 'z').Subtract(Class('a', 'b', 'y', 'z', 'k', 'k')), Class('c', 'j', 'l', 'x')},
+		{"subtract everything", Class('b', 'c').Subtract(Class('a', 'z')), Class()},
+		{"negate", Class('"', '"').Negate(), Class(0, '"' - 1, '"' + 1, 0xD7FF, 0xE000, unicode.MaxRune)},
+		{"negate twice", Class('a', 'z').Negate().Negate(), Class('a', 'z')},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !SameRanges(test.got, test.want) {
+				t.Fatalf("expected %v, got %v", test.want.Ranges, test.got.Ranges)
+			}
+		})
+	}
+}
+
+func TestCharClassNth(t *testing.T) {
+	class := Class('a', 'c', 'x', 'z')
+	if class.Size() != 6 {
+		t.Fatalf("expected the size of 6, got %d", class.Size())
+	}
+	want := "abcxyz"
+	for k, x := range []rune(want) {
+		if got := class.Nth(int64(k)); got != x {
+			t.Errorf("expected the %d-th character %c, got %c", k, x, got)
+		}
+	}
+}
+
+// The dumped classes have to be read back as the same set of characters
+func TestCharClassString(t *testing.T) {
+	tests := []ExprCharClass{
+		Class('a', 'a'),
+		Class('a', 'c', 'x', 'z'),
+		Class('"', '"').Negate(),
+	}
+	for _, class := range tests {
+		grammar, err := ParseString("a ::= "+class.String()+"\n", "test", Options{NoCore: true})
+		if err != nil {
+			t.Fatalf("%s: %s", class, err)
+		}
+		got, ok := CharClassOfExpr(nil, grammar.Rules["a"].Body, nil)
+		if !ok || !SameRanges(got, class) {
+			t.Errorf("%s is read back as %v", class, got.Ranges)
+		}
+	}
+}
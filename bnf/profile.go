@@ -0,0 +1,356 @@
+package bnf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The probabilities applied to an unmodified grammar
+//
+//   {
+//     "rules": {
+//       "numeric-reply": { "weight": 1 },
+//       "command": { "alternatives": { "0": 9, "1": 1 } },
+//       "nickname": { "repetitions": { "1": 1, "2": 5, "3": 5 } },
+//       "trailing": { "distribution": "geometric:10", "max-repetitions": 500 }
+//     }
+//   }
+type RuleProfile struct {
+	Loc Loc `json:"-"`
+	// The weight of the alternatives that consist only of this symbol in any rule
+	Weight *uint `json:"weight"`
+	// The weights of the alternatives of this rule by their index
+	Alternatives map[int]uint `json:"alternatives"`
+	// The weights of the amounts of all of the repetitions in this rule
+	Repetitions map[uint]uint `json:"repetitions"`
+	// The distribution of the amounts of all of the repetitions in this rule, see ParseDistribution
+	Distribution string `json:"distribution"`
+	// The upper bound of the repetitions in this rule that don't specify it, like `*a`
+	MaxRepetitions *uint `json:"max-repetitions"`
+}
+
+type Profile struct {
+	Rules map[string]RuleProfile
+	// The names of the Rules in the order of the file
+	Names []string
+	// The content of the file for the diagnostics
+	Sources Sources
+}
+
+func LocOfOffset(content []rune, filePath string, offset int) (loc Loc) {
+	loc.FilePath = filePath
+	bytes := 0
+	for _, x := range content {
+		if bytes >= offset {
+			break
+		}
+		bytes += len(string(x))
+		if x == '\n' {
+			loc.Row += 1
+			loc.Col = 0
+		} else {
+			loc.Col += 1
+		}
+	}
+	return
+}
+
+// The errors without an offset are reported at the loc of the last key
+func ProfileErr(content []rune, filePath string, key Loc, err error) *DiagErr {
+	loc := key
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		// The offset is right after the invalid character
+		loc = LocOfOffset(content, filePath, int(syntaxErr.Offset) - 1)
+	} else if errors.As(err, &typeErr) {
+		loc = LocOfOffset(content, filePath, int(typeErr.Offset))
+	}
+	return &DiagErr{
+		Loc: loc,
+		Code: CodeProfile,
+		Err: err,
+	}
+}
+
+// The loc of the key that has been just read by the decoder
+func KeyLoc(content []rune, filePath string, decoder *json.Decoder, key string) Loc {
+	quoted, _ := json.Marshal(key)
+	return LocOfOffset(content, filePath, int(decoder.InputOffset()) - len(quoted))
+}
+
+func ExpectJsonDelim(decoder *json.Decoder, delim json.Delim) (err error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return
+	}
+	if token != delim {
+		err = fmt.Errorf("Expected %s but got %v", delim, token)
+	}
+	return
+}
+
+// Parses the profile keeping the locations of the rule names, so the diagnostics can point to them
+func ParseProfile(content string, filePath string) (profile *Profile, err error) {
+	runes := []rune(content)
+	sources := Sources{filePath: runes}
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.DisallowUnknownFields()
+	key := Loc{FilePath: filePath}
+	fail := func(err error) (*Profile, error) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+			key = LocOfOffset(runes, filePath, len(content))
+		}
+		return nil, WithSources(ProfileErr(runes, filePath, key, err), sources)
+	}
+
+	profile = &Profile{
+		Rules: map[string]RuleProfile{},
+		Sources: sources,
+	}
+	if err = ExpectJsonDelim(decoder, '{'); err != nil {
+		return fail(err)
+	}
+	for decoder.More() {
+		var token json.Token
+		if token, err = decoder.Token(); err != nil {
+			return fail(err)
+		}
+		if field, ok := token.(string); ok {
+			key = KeyLoc(runes, filePath, decoder, field)
+		}
+		if token != "rules" {
+			return fail(fmt.Errorf("Unknown field %v. Expected \"rules\"", token))
+		}
+		if err = ExpectJsonDelim(decoder, '{'); err != nil {
+			return fail(err)
+		}
+		for decoder.More() {
+			if token, err = decoder.Token(); err != nil {
+				return fail(err)
+			}
+			name := token.(string)
+			key = KeyLoc(runes, filePath, decoder, name)
+
+			rule := RuleProfile{}
+			if err = decoder.Decode(&rule); err != nil {
+				return fail(err)
+			}
+			rule.Loc = key
+			if _, exists := profile.Rules[name]; !exists {
+				profile.Names = append(profile.Names, name)
+			}
+			profile.Rules[name] = rule
+		}
+		if err = ExpectJsonDelim(decoder, '}'); err != nil {
+			return fail(err)
+		}
+	}
+	if err = ExpectJsonDelim(decoder, '}'); err != nil {
+		return fail(err)
+	}
+	return
+}
+
+func LoadProfile(filePath string) (profile *Profile, err error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		err = &DiagErr{
+			Loc: Loc{FilePath: filePath},
+			Code: CodeProfile,
+			Err: err,
+		}
+		return
+	}
+	return ParseProfile(string(content), filePath)
+}
+
+func SetAlternativeWeight(alt *ExprAlternation, i int, weight uint) {
+	if alt.Weights == nil {
+		alt.Weights = make([]uint, len(alt.Variants))
+		for j := range alt.Weights {
+			alt.Weights[j] = 1
+		}
+	} else {
+		alt.Weights = append([]uint{}, alt.Weights...)
+	}
+	alt.Weights[i] = weight
+}
+
+// Sets the weights of the alternatives that consist only of a symbol with a weight
+func ApplySymbolWeightsInExpr(expr Expr, weights map[string]uint) Expr {
+	switch expr := expr.(type) {
+	case ExprAlternation:
+		variants := []Expr{}
+		for i := range expr.Variants {
+			if symbol, ok := expr.Variants[i].(ExprSymbol); ok {
+				if weight, ok := weights[symbol.Name]; ok {
+					SetAlternativeWeight(&expr, i, weight)
+				}
+			}
+			variants = append(variants, ApplySymbolWeightsInExpr(expr.Variants[i], weights))
+		}
+		expr.Variants = variants
+		return expr
+	case ExprConcat:
+		elements := []Expr{}
+		for i := range expr.Elements {
+			elements = append(elements, ApplySymbolWeightsInExpr(expr.Elements[i], weights))
+		}
+		expr.Elements = elements
+		return expr
+	case ExprRepetition:
+		expr.Body = ApplySymbolWeightsInExpr(expr.Body, weights)
+		return expr
+	}
+	return expr
+}
+
+// Rewrites every repetition in the expr including the nested ones
+func MapRepetitionsInExpr(expr Expr, f func(ExprRepetition) ExprRepetition) Expr {
+	switch expr := expr.(type) {
+	case ExprAlternation:
+		variants := []Expr{}
+		for i := range expr.Variants {
+			variants = append(variants, MapRepetitionsInExpr(expr.Variants[i], f))
+		}
+		expr.Variants = variants
+		return expr
+	case ExprConcat:
+		elements := []Expr{}
+		for i := range expr.Elements {
+			elements = append(elements, MapRepetitionsInExpr(expr.Elements[i], f))
+		}
+		expr.Elements = elements
+		return expr
+	case ExprRepetition:
+		expr.Body = MapRepetitionsInExpr(expr.Body, f)
+		return f(expr)
+	}
+	return expr
+}
+
+// Sets the upper bound of the repetitions that don't specify it in the grammar
+func SetImplicitUpperBound(expr Expr, upper uint) Expr {
+	return MapRepetitionsInExpr(expr, func(repetition ExprRepetition) ExprRepetition {
+		if repetition.Unbounded {
+			repetition.Upper = upper
+			if repetition.Upper < repetition.Lower {
+				repetition.Upper = repetition.Lower
+			}
+		}
+		return repetition
+	})
+}
+
+func SetDistribution(expr Expr, dist *Distribution) Expr {
+	return MapRepetitionsInExpr(expr, func(repetition ExprRepetition) ExprRepetition {
+		repetition.Distribution = dist
+		return repetition
+	})
+}
+
+// Applies the weights and the distributions of the profile to the rules of the grammar. The names
+// of the profile that are not defined in the grammar are reported like the undefined symbols.
+func (grammar *Grammar) ApplyProfile(profile *Profile) (errs Errors) {
+	sources := MergeSources(grammar.Sources, profile.Sources)
+	weights := map[string]uint{}
+	for _, name := range profile.Names {
+		rule := profile.Rules[name]
+		if _, exists := grammar.Rules[name]; !exists {
+			quoted, _ := json.Marshal(name)
+			errs.Add(&DiagErr{
+				Loc: rule.Loc,
+				Len: len([]rune(string(quoted))),
+				Code: CodeUndefinedSymbol,
+				Err: fmt.Errorf("Symbol %s is not defined", name),
+				Help: SymbolHelp(grammar.Rules, name),
+			})
+			continue
+		}
+		if rule.Weight != nil {
+			weights[name] = *rule.Weight
+		}
+	}
+	if len(errs) > 0 {
+		errs.WithSources(sources)
+		return
+	}
+
+	for name, rule := range grammar.Rules {
+		rule.Body = ApplySymbolWeightsInExpr(rule.Body, weights)
+		grammar.Rules[name] = rule
+	}
+
+	for _, name := range profile.Names {
+		ruleProfile := profile.Rules[name]
+		rule := grammar.Rules[name]
+		if len(ruleProfile.Alternatives) > 0 {
+			alt, ok := rule.Body.(ExprAlternation)
+			if !ok {
+				errs.Add(&DiagErr{
+					Loc: ruleProfile.Loc,
+					Code: CodeProfile,
+					Err: fmt.Errorf("%s has no alternatives to weigh", name),
+					Notes: []DiagNote{{
+						Loc: rule.Head.Loc,
+						Message: fmt.Sprintf("%s is defined here", name),
+					}},
+				})
+				continue
+			}
+			indices := []int{}
+			for i := range ruleProfile.Alternatives {
+				indices = append(indices, i)
+			}
+			sort.Ints(indices)
+			for _, i := range indices {
+				if i < 0 || i >= len(alt.Variants) {
+					errs.Add(&DiagErr{
+						Loc: ruleProfile.Loc,
+						Code: CodeProfile,
+						Err: fmt.Errorf("%s has only %d alternatives, but the profile weighs the alternative %d", name, len(alt.Variants), i),
+					})
+					continue
+				}
+				SetAlternativeWeight(&alt, i, ruleProfile.Alternatives[i])
+			}
+			rule.Body = alt
+		}
+		if ruleProfile.MaxRepetitions != nil {
+			rule.Body = SetImplicitUpperBound(rule.Body, *ruleProfile.MaxRepetitions)
+		}
+		if len(ruleProfile.Repetitions) > 0 && len(ruleProfile.Distribution) > 0 {
+			errs.Add(&DiagErr{
+				Loc: ruleProfile.Loc,
+				Code: CodeProfile,
+				Err: fmt.Errorf("%s has both the repetitions and the distribution. Only one of them can be used.", name),
+			})
+		} else if len(ruleProfile.Repetitions) > 0 {
+			rule.Body = SetDistribution(rule.Body, &Distribution{
+				Kind: DistributionCounts,
+				Counts: ruleProfile.Repetitions,
+			})
+		} else if len(ruleProfile.Distribution) > 0 {
+			dist, err := ParseDistribution(ruleProfile.Distribution)
+			if err != nil {
+				errs.Add(&DiagErr{
+					Loc: ruleProfile.Loc,
+					Code: CodeProfile,
+					Err: err,
+				})
+			} else {
+				rule.Body = SetDistribution(rule.Body, dist)
+			}
+		}
+		grammar.Rules[name] = rule
+	}
+	errs.WithSources(sources)
+	return
+}
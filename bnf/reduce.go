@@ -0,0 +1,249 @@
+package bnf
+
+import (
+	"sort"
+)
+
+// Computes the shortest message of every rule. A rule only gets a message once its body can be
+// finished with the messages of the other rules, so the messages never recurse like MinHeights.
+func ShortestMessages(grammar map[string]Rule) map[string][]rune {
+	names := []string{}
+	for name := range grammar {
+		names = append(names, name)
+	}
+	// The messages of the equally short derivations don't depend on the order of the map
+	sort.Strings(names)
+	messages := map[string][]rune{}
+	for changed := true; changed; {
+		changed = false
+		for _, name := range names {
+			message, ok := ShortestMessageOfExpr(messages, grammar[name].Body)
+			if current, done := messages[name]; ok && (!done || len(message) < len(current)) {
+				messages[name] = message
+				changed = true
+			}
+		}
+	}
+	return messages
+}
+
+// The shortest message of the expr made of the shortest messages of the rules. The strings keep
+// their case and the ranges give their lowest character. Not ok if the expr can not be finished
+// with the messages.
+func ShortestMessageOfExpr(messages map[string][]rune, expr Expr) (message []rune, ok bool) {
+	switch expr := expr.(type) {
+	case ExprSymbol:
+		message, ok = messages[expr.Name]
+	case ExprString:
+		message, ok = append(message, expr.Text...), true
+	case ExprConcat:
+		for _, element := range expr.Elements {
+			elementMessage, elementOk := ShortestMessageOfExpr(messages, element)
+			if !elementOk {
+				return nil, false
+			}
+			message = append(message, elementMessage...)
+		}
+		ok = true
+	case ExprAlternation:
+		for _, variant := range expr.Variants {
+			variantMessage, variantOk := ShortestMessageOfExpr(messages, variant)
+			if variantOk && (!ok || len(variantMessage) < len(message)) {
+				message, ok = variantMessage, true
+			}
+		}
+	case ExprRepetition:
+		ok = true
+		if expr.Lower == 0 {
+			return
+		}
+		var body []rune
+		if body, ok = ShortestMessageOfExpr(messages, expr.Body); !ok {
+			return
+		}
+		for i := uint(0); i < expr.Lower; i += 1 {
+			message = append(message, body...)
+		}
+	case ExprRange:
+		message, ok = append(message, expr.Lower), true
+	case ExprCharClass:
+		if len(expr.Ranges) > 0 {
+			message, ok = append(message, expr.Ranges[0].Lower), true
+		}
+	}
+	return
+}
+
+// Shrinks the inputs that match the grammar while the Predicate holds for them. Every candidate
+// is derived from the parse tree of the current input:
+//
+// - a derivation is replaced with the shortest message of its expression,
+// - the iterations of a repetition above its lower bound are removed,
+// - a derivation of a rule is replaced with a nested derivation of the same rule.
+//
+// So the candidates match the grammar by construction, but they are checked by the Recognizer
+// anyway before the Predicate.
+type Reducer struct {
+	Recognizer *Recognizer
+	// Whether the candidate still shows the problem, e.g. still crashes the target
+	Predicate func(input []rune) bool
+	// The shortest messages of the rules, see ShortestMessages
+	Messages map[string][]rune
+	// How many times the Predicate was called
+	Tests int
+}
+
+func NewReducer(rec *Recognizer, predicate func(input []rune) bool) *Reducer {
+	return &Reducer{
+		Recognizer: rec,
+		Predicate: predicate,
+		Messages: ShortestMessages(rec.Grammar.Rules),
+	}
+}
+
+// Replaces the span of the input with the replacement
+func Splice(input []rune, start int, end int, replacement []rune) (result []rune) {
+	result = append(result, input[:start]...)
+	result = append(result, replacement...)
+	result = append(result, input[end:]...)
+	return
+}
+
+type Span struct {
+	Start int
+	End int
+}
+
+func SpanOf(trees []*ParseTree) Span {
+	return Span{Start: trees[0].Start, End: trees[len(trees) - 1].End}
+}
+
+// The spans of the iterations of the derivation of a repetition
+func (red *Reducer) Iterations(tree *ParseTree) (spans []Span) {
+	nt := red.Recognizer.Nonterminals[tree.Nonterminal]
+	size := nt.IterationSize
+	children := tree.Children
+	if size == 0 || len(children) == 0 {
+		return
+	}
+	isOptional := func(child *ParseTree) bool {
+		return child.Nonterminal >= 0 && red.Recognizer.Nonterminals[child.Nonterminal].Optional
+	}
+	if !nt.Optional {
+		// body{Lower} Optional
+		for len(children) >= size && !(len(children) == 1 && isOptional(children[0])) {
+			spans = append(spans, SpanOf(children[:size]))
+			children = children[size:]
+		}
+		if len(children) == 1 {
+			spans = append(spans, red.Iterations(children[0])...)
+		}
+		return
+	}
+	// Optional body or body Optional
+	if isOptional(children[0]) {
+		spans = append(spans, red.Iterations(children[0])...)
+		spans = append(spans, SpanOf(children[1:]))
+	} else if isOptional(children[len(children) - 1]) {
+		spans = append(spans, SpanOf(children[:len(children) - 1]))
+		spans = append(spans, red.Iterations(children[len(children) - 1])...)
+	} else {
+		spans = append(spans, SpanOf(children))
+	}
+	return
+}
+
+// The candidates that are shorter than the input, the shortest ones first
+func (red *Reducer) Candidates(input []rune, tree *ParseTree) (candidates [][]rune) {
+	rec := red.Recognizer
+	seen := map[string]bool{}
+	add := func(start int, end int, replacement []rune) {
+		if len(replacement) >= end - start {
+			return
+		}
+		candidate := Splice(input, start, end, replacement)
+		if !seen[string(candidate)] {
+			seen[string(candidate)] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+	// The nearest derivations of the rule nested in the tree
+	var nested func(tree *ParseTree, nt int) []*ParseTree
+	nested = func(tree *ParseTree, nt int) (result []*ParseTree) {
+		for _, child := range tree.Children {
+			if child.Nonterminal == nt {
+				result = append(result, child)
+			} else if child.Nonterminal >= 0 {
+				result = append(result, nested(child, nt)...)
+			}
+		}
+		return
+	}
+	var visit func(tree *ParseTree)
+	visit = func(tree *ParseTree) {
+		if tree.Nonterminal < 0 {
+			return
+		}
+		nt := rec.Nonterminals[tree.Nonterminal]
+		if nt.Optional {
+			add(tree.Start, tree.End, nil)
+			for _, child := range tree.Children {
+				if child.Nonterminal >= 0 && rec.Nonterminals[child.Nonterminal].Optional {
+					add(tree.Start, tree.End, input[child.Start:child.End])
+				}
+			}
+		} else if message, ok := ShortestMessageOfExpr(red.Messages, nt.Expr); ok {
+			add(tree.Start, tree.End, message)
+		}
+		if repetition, ok := nt.Expr.(ExprRepetition); ok && !nt.IsRule && !nt.Optional {
+			// Any of the iterations can go as long as there are more of them than the lower bound requires
+			if iterations := red.Iterations(tree); uint(len(iterations)) > repetition.Lower {
+				for _, span := range iterations {
+					add(span.Start, span.End, nil)
+				}
+			}
+		}
+		if nt.IsRule {
+			for _, child := range nested(tree, tree.Nonterminal) {
+				add(tree.Start, tree.End, input[child.Start:child.End])
+			}
+		}
+		for _, child := range tree.Children {
+			visit(child)
+		}
+	}
+	visit(tree)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i]) < len(candidates[j])
+	})
+	return
+}
+
+// Shrinks the input until none of the candidates hold the Predicate. The input must match the grammar.
+func (red *Reducer) Reduce(input []rune, filePath string) (result []rune, err error) {
+	chart, err := red.Recognizer.Match(input, filePath)
+	if err != nil {
+		return
+	}
+	result = input
+	for {
+		tree := red.Recognizer.Forest(chart).Trees(1)[0]
+		progress := false
+		for _, candidate := range red.Candidates(result, tree) {
+			candidateChart := red.Recognizer.Parse(candidate)
+			if !red.Recognizer.Accepted(candidateChart) {
+				continue
+			}
+			red.Tests += 1
+			if red.Predicate(candidate) {
+				result = candidate
+				chart = candidateChart
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			return
+		}
+	}
+}
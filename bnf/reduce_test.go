@@ -0,0 +1,75 @@
+package bnf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShortestMessages(t *testing.T) {
+	tests := []struct {
+		grammar string
+		rule string
+		message string
+	}{
+		{"a = 3\"x\" / \"yy\"\n", "a", "yy"},
+		{"a = *\"x\" \"y\"\n", "a", "y"},
+		{"a = %x41-43 %i\"b\"\n", "a", "Ab"},
+		// The recursive variants tie with the terminals
+		{"s = x x\nx = y / \"a\"\ny = x\n", "y", "a"},
+		{"a = \"(\" a \")\" / b\nb = \"x\"\n", "a", "x"},
+	}
+	for _, test := range tests {
+		grammar := MustParse(t, test.grammar, "test.abnf")
+		messages := ShortestMessages(grammar.Rules)
+		if message := string(messages[test.rule]); message != test.message {
+			t.Errorf("%q: expected %q, got %q", test.grammar, test.message, message)
+		}
+	}
+}
+
+func TestReduce(t *testing.T) {
+	tests := []struct {
+		grammar string
+		entry string
+		input string
+		// The Predicate holds while the input contains it
+		keep string
+		want string
+	}{
+		{"m = 1*l \".\"\nl = \"a\" / \"z\"\n", "m", "aazaa.", "z", "z."},
+		{"m = 1*w *( \" \" 1*w ) CRLF\nw = ALPHA\n", "m", "Tz foo bar\r\n", "z", "z\r\n"},
+		{"e = e \"+\" e / \"(\" e \")\" / DIGIT\n", "e", "1+(2+(3+4))+5", "3+4", "3+4"},
+		{"s = x x\nx = y / \"a\"\ny = x\n", "s", "aa", "", "aa"},
+	}
+	for _, test := range tests {
+		grammar := MustParse(t, test.grammar, "test.abnf")
+		rec, err := NewRecognizer(grammar, test.entry)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		red := NewReducer(rec, func(input []rune) bool {
+			return strings.Contains(string(input), test.keep)
+		})
+		result, err := red.Reduce([]rune(test.input), "input")
+		if err != nil {
+			t.Fatalf("%q: %s", test.grammar, err)
+		}
+		if string(result) != test.want {
+			t.Errorf("%q reducing %q: expected %q, got %q", test.grammar, test.input, test.want, string(result))
+		}
+	}
+}
+
+func TestReduceRejectsMismatch(t *testing.T) {
+	grammar := MustParse(t, "a = \"x\"\n", "test.abnf")
+	rec, err := NewRecognizer(grammar, "a")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	red := NewReducer(rec, func(input []rune) bool {
+		return true
+	})
+	if _, err := red.Reduce([]rune("y"), "input"); err == nil || AsDiagErr(err).Code != CodeNoMatch {
+		t.Fatalf("expected %s, got %v", CodeNoMatch, err)
+	}
+}
@@ -0,0 +1,437 @@
+package bnf
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// A derivation of the input span [Start, End) from a Nonterminal of the Recognizer, or a single
+// matched character if Nonterminal is -1. Start and End are the indices of the characters of the input.
+type ParseTree struct {
+	Nonterminal int
+	// The Production the Nonterminal was derived with
+	Prod int
+	// The Terminal the character was matched with
+	Terminal Expr
+	Start int
+	End int
+	Children []*ParseTree
+}
+
+type SpanKey struct {
+	Nonterminal int
+	Start int
+	End int
+}
+
+type SeqKey struct {
+	Prod int
+	Dot int
+	Start int
+	End int
+}
+
+// Extracts the derivations from the Chart of an accepted input. The derivations that go through the
+// same Nonterminal over the same span again, like `a = a / "x"`, would make the amount of trees
+// infinite, so they are skipped.
+//
+// Such a derivation can only go through the spans with the same bounds, so every memoized result
+// keeps the spans with its own bounds it went through. The result is reused only while none of them
+// is being derived above it, and it is not memoized at all if it skipped a span being derived above it.
+type Forest struct {
+	Recognizer *Recognizer
+	Chart *Chart
+	// Where the Nonterminals that start at the position end: Ends[{nt, start, start}] is the list of the ends
+	Ends map[SpanKey][]int
+	// The limit of the amount of trees per span. 0 means no limit.
+	Limit int
+	trees map[SpanKey]SpanMemo
+	seqs map[SeqKey]SpanMemo
+	counts map[SpanKey]SpanMemo
+	seqCounts map[SeqKey]SpanMemo
+	// The depths of the spans being derived, starting from 1
+	active map[SpanKey]int
+	depth int
+	// The smallest depth of the spans being derived that the current derivation skipped
+	low int
+	// The spans the current derivation went through
+	reach []SpanKey
+}
+
+type SpanMemo struct {
+	Trees []*ParseTree
+	Seqs [][]*ParseTree
+	Count *big.Int
+	// The spans with the same bounds the result went through
+	Reach []SpanKey
+}
+
+func (rec *Recognizer) Forest(chart *Chart) *Forest {
+	forest := &Forest{
+		Recognizer: rec,
+		Chart: chart,
+		Ends: map[SpanKey][]int{},
+		active: map[SpanKey]int{},
+	}
+	for end := range chart.Sets {
+		seen := map[SpanKey]bool{}
+		for _, item := range chart.Sets[end].Items {
+			if !rec.IsComplete(item) {
+				continue
+			}
+			key := SpanKey{Nonterminal: rec.Productions[item.Prod].Head, Start: item.Origin, End: item.Origin}
+			if !seen[key] {
+				seen[key] = true
+				forest.Ends[key] = append(forest.Ends[key], end)
+			}
+		}
+	}
+	return forest
+}
+
+// Up to limit derivations of the whole input from the start symbol. 0 means all of them.
+func (forest *Forest) Trees(limit int) []*ParseTree {
+	forest.Limit = limit
+	forest.trees = map[SpanKey]SpanMemo{}
+	forest.seqs = map[SeqKey]SpanMemo{}
+	forest.low = math.MaxInt
+	return forest.SpanTrees(SpanKey{Nonterminal: forest.Recognizer.Start, Start: 0, End: len(forest.Chart.Input)})
+}
+
+func (forest *Forest) Full(n int) bool {
+	return forest.Limit > 0 && n >= forest.Limit
+}
+
+// Whether the memoized result can be reused, that is none of the spans it went through is being derived
+func (forest *Forest) Reuse(memo SpanMemo, ok bool) bool {
+	if !ok {
+		return false
+	}
+	for _, key := range memo.Reach {
+		if forest.active[key] > 0 {
+			return false
+		}
+	}
+	forest.reach = append(forest.reach, memo.Reach...)
+	return true
+}
+
+// Starts the derivation of a span or, with the zero depth, of a sequence over the bounds of the key.
+// Returns what Leave needs to restore.
+func (forest *Forest) Enter(key SpanKey, depth int) (low int, mark int) {
+	low, forest.low = forest.low, math.MaxInt
+	mark = len(forest.reach)
+	if depth > 0 {
+		forest.depth = depth
+		forest.active[key] = depth
+		forest.reach = append(forest.reach, key)
+	}
+	return
+}
+
+// Finishes the derivation started with Enter. Returns the memo of the result if it can be memoized.
+func (forest *Forest) Leave(key SpanKey, depth int, low int, mark int) (memo SpanMemo, ok bool) {
+	seen := map[SpanKey]bool{}
+	for _, span := range forest.reach[mark:] {
+		if span.Start == key.Start && span.End == key.End && !seen[span] {
+			seen[span] = true
+			memo.Reach = append(memo.Reach, span)
+		}
+	}
+	forest.reach = append(forest.reach[:mark], memo.Reach...)
+	if depth > 0 {
+		delete(forest.active, key)
+		forest.depth = depth - 1
+	} else {
+		// The sequences have no span of their own, so any skipped span is above them
+		depth = forest.depth + 1
+	}
+	ok = forest.low >= depth
+	if ok || low < forest.low {
+		forest.low = low
+	}
+	return
+}
+
+// Skips the span if it is being derived
+func (forest *Forest) Skip(key SpanKey) bool {
+	depth := forest.active[key]
+	if depth == 0 {
+		return false
+	}
+	if depth < forest.low {
+		forest.low = depth
+	}
+	forest.reach = append(forest.reach, key)
+	return true
+}
+
+func (forest *Forest) SpanTrees(key SpanKey) (result []*ParseTree) {
+	if memo, ok := forest.trees[key]; forest.Reuse(memo, ok) {
+		return memo.Trees
+	}
+	if forest.Skip(key) {
+		return
+	}
+	depth := forest.depth + 1
+	low, mark := forest.Enter(key, depth)
+	rec := forest.Recognizer
+	for _, prod := range rec.Nonterminals[key.Nonterminal].Productions {
+		for _, children := range forest.SeqTrees(SeqKey{Prod: prod, Dot: 0, Start: key.Start, End: key.End}) {
+			if forest.Full(len(result)) {
+				break
+			}
+			result = append(result, &ParseTree{
+				Nonterminal: key.Nonterminal,
+				Prod: prod,
+				Start: key.Start,
+				End: key.End,
+				Children: children,
+			})
+		}
+	}
+	if memo, ok := forest.Leave(key, depth, low, mark); ok {
+		memo.Trees = result
+		forest.trees[key] = memo
+	}
+	return
+}
+
+// The derivations of the span by the symbols of the production starting from the dot
+func (forest *Forest) SeqTrees(key SeqKey) (result [][]*ParseTree) {
+	if memo, ok := forest.seqs[key]; forest.Reuse(memo, ok) {
+		return memo.Seqs
+	}
+	span := SpanKey{Nonterminal: -1, Start: key.Start, End: key.End}
+	low, mark := forest.Enter(span, 0)
+	defer func() {
+		if memo, ok := forest.Leave(span, 0, low, mark); ok {
+			memo.Seqs = result
+			forest.seqs[key] = memo
+		}
+	}()
+	rec := forest.Recognizer
+	body := rec.Productions[key.Prod].Body
+	if key.Dot == len(body) {
+		if key.Start == key.End {
+			result = [][]*ParseTree{{}}
+		}
+		return
+	}
+	symbol := body[key.Dot]
+	rest := SeqKey{Prod: key.Prod, Dot: key.Dot + 1, End: key.End}
+	if symbol.Nonterminal < 0 {
+		if key.Start < key.End && MatchTerminal(symbol.Terminal, forest.Chart.Input[key.Start]) {
+			leaf := &ParseTree{
+				Nonterminal: -1,
+				Terminal: symbol.Terminal,
+				Start: key.Start,
+				End: key.Start + 1,
+			}
+			rest.Start = key.Start + 1
+			for _, children := range forest.SeqTrees(rest) {
+				if forest.Full(len(result)) {
+					break
+				}
+				result = append(result, append([]*ParseTree{leaf}, children...))
+			}
+		}
+		return
+	}
+	for _, end := range forest.Ends[SpanKey{Nonterminal: symbol.Nonterminal, Start: key.Start, End: key.Start}] {
+		if end > key.End {
+			continue
+		}
+		rest.Start = end
+		restTrees := forest.SeqTrees(rest)
+		if len(restTrees) == 0 {
+			continue
+		}
+		for _, tree := range forest.SpanTrees(SpanKey{Nonterminal: symbol.Nonterminal, Start: key.Start, End: end}) {
+			for _, children := range restTrees {
+				if forest.Full(len(result)) {
+					break
+				}
+				result = append(result, append([]*ParseTree{tree}, children...))
+			}
+		}
+	}
+	return
+}
+
+// The amount of derivations of the whole input from the start symbol
+func (forest *Forest) Count() *big.Int {
+	forest.counts = map[SpanKey]SpanMemo{}
+	forest.seqCounts = map[SeqKey]SpanMemo{}
+	forest.low = math.MaxInt
+	return forest.SpanCount(SpanKey{Nonterminal: forest.Recognizer.Start, Start: 0, End: len(forest.Chart.Input)})
+}
+
+func (forest *Forest) SpanCount(key SpanKey) *big.Int {
+	if memo, ok := forest.counts[key]; forest.Reuse(memo, ok) {
+		return memo.Count
+	}
+	count := big.NewInt(0)
+	if forest.Skip(key) {
+		return count
+	}
+	depth := forest.depth + 1
+	low, mark := forest.Enter(key, depth)
+	for _, prod := range forest.Recognizer.Nonterminals[key.Nonterminal].Productions {
+		count.Add(count, forest.SeqCount(SeqKey{Prod: prod, Dot: 0, Start: key.Start, End: key.End}))
+	}
+	if memo, ok := forest.Leave(key, depth, low, mark); ok {
+		memo.Count = count
+		forest.counts[key] = memo
+	}
+	return count
+}
+
+func (forest *Forest) SeqCount(key SeqKey) *big.Int {
+	if memo, ok := forest.seqCounts[key]; forest.Reuse(memo, ok) {
+		return memo.Count
+	}
+	span := SpanKey{Nonterminal: -1, Start: key.Start, End: key.End}
+	low, mark := forest.Enter(span, 0)
+	count := big.NewInt(0)
+	body := forest.Recognizer.Productions[key.Prod].Body
+	rest := SeqKey{Prod: key.Prod, Dot: key.Dot + 1, End: key.End}
+	if key.Dot == len(body) {
+		if key.Start == key.End {
+			count.SetInt64(1)
+		}
+	} else if symbol := body[key.Dot]; symbol.Nonterminal < 0 {
+		if key.Start < key.End && MatchTerminal(symbol.Terminal, forest.Chart.Input[key.Start]) {
+			rest.Start = key.Start + 1
+			count.Set(forest.SeqCount(rest))
+		}
+	} else {
+		for _, end := range forest.Ends[SpanKey{Nonterminal: symbol.Nonterminal, Start: key.Start, End: key.Start}] {
+			if end > key.End {
+				continue
+			}
+			rest.Start = end
+			restCount := forest.SeqCount(rest)
+			if restCount.Sign() == 0 {
+				continue
+			}
+			ways := new(big.Int).Mul(forest.SpanCount(SpanKey{Nonterminal: symbol.Nonterminal, Start: key.Start, End: end}), restCount)
+			count.Add(count, ways)
+		}
+	}
+	if memo, ok := forest.Leave(span, 0, low, mark); ok {
+		memo.Count = count
+		forest.seqCounts[key] = memo
+	}
+	return count
+}
+
+// The view of a ParseTree for the users. Only the rules become the nodes, the parts of their bodies
+// are flattened into them and the adjacent characters are joined into a single Text leaf.
+type ParseTreeJson struct {
+	Rule string `json:"rule,omitempty"`
+	// The index of the alternative of the rule the node was derived with
+	Alternative *int `json:"alternative,omitempty"`
+	Text *string `json:"text,omitempty"`
+	// The byte offsets of the span of the input
+	Start int `json:"start"`
+	End int `json:"end"`
+	// Where the rule is defined in the grammar
+	File string `json:"file,omitempty"`
+	Line int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+	Children []*ParseTreeJson `json:"children,omitempty"`
+}
+
+// The byte offsets of the characters of the input. Octets are a byte each in the Bytes mode.
+func (rec *Recognizer) ByteOffsets(input []rune) (offsets []int) {
+	offsets = make([]int, len(input) + 1)
+	for i, x := range input {
+		size := 1
+		if !rec.Bytes {
+			size = utf8.RuneLen(x)
+		}
+		offsets[i + 1] = offsets[i] + size
+	}
+	return
+}
+
+func (rec *Recognizer) TreeAsJson(tree *ParseTree, input []rune) *ParseTreeJson {
+	offsets := rec.ByteOffsets(input)
+	var build func(tree *ParseTree) *ParseTreeJson
+	var flatten func(node *ParseTreeJson, children []*ParseTree)
+	flatten = func(node *ParseTreeJson, children []*ParseTree) {
+		for _, child := range children {
+			if child.Nonterminal >= 0 && rec.Nonterminals[child.Nonterminal].IsRule {
+				node.Children = append(node.Children, build(child))
+				continue
+			}
+			if child.Nonterminal >= 0 {
+				flatten(node, child.Children)
+				continue
+			}
+			n := len(node.Children)
+			if n > 0 && node.Children[n - 1].Text != nil && node.Children[n - 1].End == offsets[child.Start] {
+				last := node.Children[n - 1]
+				*last.Text += string(input[child.Start])
+				last.End = offsets[child.End]
+				continue
+			}
+			text := string(input[child.Start])
+			node.Children = append(node.Children, &ParseTreeJson{
+				Text: &text,
+				Start: offsets[child.Start],
+				End: offsets[child.End],
+			})
+		}
+	}
+	build = func(tree *ParseTree) *ParseTreeJson {
+		nt := rec.Nonterminals[tree.Nonterminal]
+		loc := rec.Grammar.Rules[nt.Rule].Head.Loc
+		node := &ParseTreeJson{
+			Rule: nt.Rule,
+			Start: offsets[tree.Start],
+			End: offsets[tree.End],
+			File: loc.FilePath,
+			Line: loc.Row + 1,
+			Column: loc.Col + 1,
+		}
+		if variant := rec.Productions[tree.Prod].Variant; variant >= 0 {
+			node.Alternative = &variant
+		}
+		flatten(node, tree.Children)
+		return node
+	}
+	return build(tree)
+}
+
+// Renders the tree as an S-expression, one rule per line:
+//
+//   (message :alternative 0 :start 0 :end 20 :loc "irc.bnf:1:1"
+//     "PRIVMSG" ...)
+func (node *ParseTreeJson) SExpr() string {
+	sb := strings.Builder{}
+	var write func(node *ParseTreeJson, indent int)
+	write = func(node *ParseTreeJson, indent int) {
+		if node.Text != nil {
+			sb.WriteString(fmt.Sprintf("%q", *node.Text))
+			return
+		}
+		sb.WriteString(fmt.Sprintf("(%s", node.Rule))
+		if node.Alternative != nil {
+			sb.WriteString(fmt.Sprintf(" :alternative %d", *node.Alternative))
+		}
+		sb.WriteString(fmt.Sprintf(" :start %d :end %d :loc \"%s:%d:%d\"", node.Start, node.End, node.File, node.Line, node.Column))
+		for _, child := range node.Children {
+			sb.WriteString("\n")
+			sb.WriteString(strings.Repeat("  ", indent + 1))
+			write(child, indent + 1)
+		}
+		sb.WriteString(")")
+	}
+	write(node, 0)
+	return sb.String()
+}
@@ -1,342 +1,504 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
-	"math/rand"
+	"io"
+	"math/big"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
-	"sort"
 	"time"
+
+	"github.com/rexim/bnfuzzer/bnf"
 )
 
-// TODO: limit the amount of loops
-func GenerateRandomMessage(grammar map[string]Rule, expr Expr) (message []rune, err error) {
-	switch expr := expr.(type) {
-	case ExprString:
-		message = expr.Text
-	case ExprSymbol:
-		nextExpr, ok := grammar[expr.Name]
-		if !ok {
-			err = &DiagErr{
-				Loc: expr.Loc,
-				Err: fmt.Errorf("Symbol <%s> is not defined", expr.Name),
-			}
-			return
-		}
-		message, err = GenerateRandomMessage(grammar, nextExpr.Body)
-	case ExprConcat:
-		for i := range expr.Elements {
-			var element []rune
-			element, err = GenerateRandomMessage(grammar, expr.Elements[i])
-			if err != nil {
-				return
-			}
-			message = append(message, element...)
-		}
-	case ExprAlternation:
-		i := rand.Int31n(int32(len(expr.Variants)))
-		message, err = GenerateRandomMessage(grammar, expr.Variants[i])
-	case ExprRepetition:
-		if expr.Lower > expr.Upper {
-			err = &DiagErr{
-				Loc: expr.Loc,
-				Err: fmt.Errorf("Upper bound of the repetition is lower than the lower one."),
-			}
-			return
-		}
-		n := int(int32(expr.Lower) + rand.Int31n(int32(expr.Upper - expr.Lower + 1)))
-		for i := 0; i < n; i += 1 {
-			var childMessage []rune
-			childMessage, err = GenerateRandomMessage(grammar, expr.Body)
-			if err != nil {
-				return
-			}
-			message = append(message, childMessage...)
-		}
-	case ExprRange:
-		if expr.Lower > expr.Upper {
-			err = &DiagErr{
-				Loc: expr.Loc,
-				Err: fmt.Errorf("Upper bound of the range is lower than the lower one."),
-			}
-			return
-		}
+var DiagFormats = []string{"text", "json", "sarif"}
 
-		message = append(message, expr.Lower + rand.Int31n(expr.Upper - expr.Lower + 1))
-	default:
-		panic("unreachable")
+// The diagnostics are printed right away in the text format. The machine-readable formats
+// collect them and print them all at once in FlushDiagnostics.
+var DiagFormat = "text"
+var Diagnostics = bnf.Errors{}
+
+func ReportError(err error) {
+	diag, ok := err.(*bnf.DiagErr)
+	if !ok {
+		diag = &bnf.DiagErr{Err: err}
 	}
-	return
+	if DiagFormat != "text" {
+		Diagnostics = append(Diagnostics, diag)
+		return
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return
+	}
+	fmt.Fprint(os.Stderr, diag.Render())
 }
 
-func VerifyThatAllSymbolsDefinedInExpr(grammar map[string]Rule, expr Expr) (ok bool) {
-	ok = true
-	switch expr := expr.(type) {
-	case ExprSymbol:
-		if _, exists := grammar[expr.Name]; !exists {
-			ok = false
-			fmt.Fprintf(os.Stderr, "%s: ERROR: Symbol %s is not defined\n", expr.Loc, expr.Name)
-		}
-		return
+func ReportErrors(errs bnf.Errors) {
+	for _, err := range errs {
+		ReportError(err)
+	}
+}
 
-	case ExprAlternation:
-		for i := range expr.Variants {
-			if !VerifyThatAllSymbolsDefinedInExpr(grammar, expr.Variants[i]) {
-				ok = false
-			}
-		}
+// Prints the diagnostics collected in the machine-readable formats to stderr
+func FlushDiagnostics() {
+	var report interface{}
+	switch DiagFormat {
+	case "json":
+		report = bnf.ErrorsAsJson(Diagnostics)
+	case "sarif":
+		report = bnf.ErrorsAsSarif(Diagnostics)
+	default:
 		return
+	}
+	encoder := json.NewEncoder(os.Stderr)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	Diagnostics = bnf.Errors{}
+}
 
-	case ExprConcat:
-		for i := range expr.Elements {
-			if !VerifyThatAllSymbolsDefinedInExpr(grammar, expr.Elements[i]) {
-				ok = false
-			}
-		}
-		return
+// os.Exit does not run the deferred calls, so the collected diagnostics must be flushed explicitly
+func Exit(code int) {
+	FlushDiagnostics()
+	os.Exit(code)
+}
 
-	case ExprRepetition:
-		if !VerifyThatAllSymbolsDefinedInExpr(grammar, expr.Body) {
-			ok = false
-		}
+func ReadInput(filePath string, bytes bool) (input []rune, err error) {
+	var data []byte
+	if filePath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filePath)
+	}
+	if err != nil {
 		return
+	}
+	if bytes {
+		input = make([]rune, len(data))
+		for i, x := range data {
+			input[i] = rune(x)
+		}
+	} else {
+		input = []rune(string(data))
+	}
+	return
+}
 
-	case ExprString:
-		return
+var TreeFormats = []string{"json", "sexpr"}
+var TreeModes = []string{"first", "all", "count"}
 
-	case ExprRange:
-		return
+func Contains(names []string, name string) bool {
+	for _, x := range names {
+		if x == name {
+			return true
+		}
+	}
+	return false
+}
 
-	default: panic("unreachable")
+type TreesJson struct {
+	File string `json:"file"`
+	Count *big.Int `json:"count"`
+	Trees []*bnf.ParseTreeJson `json:"trees,omitempty"`
+}
+
+func PrintTrees(rec *bnf.Recognizer, chart *bnf.Chart, name string, format string, mode string) {
+	forest := rec.Forest(chart)
+	result := TreesJson{
+		File: name,
+		Count: forest.Count(),
+	}
+	if mode != "count" {
+		limit := 1
+		if mode == "all" {
+			limit = 0
+		}
+		for _, tree := range forest.Trees(limit) {
+			result.Trees = append(result.Trees, rec.TreeAsJson(tree, chart.Input))
+		}
+	}
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(result)
+	case "sexpr":
+		fmt.Printf("; %s: %s parse tree(s)\n", name, result.Count)
+		for _, tree := range result.Trees {
+			fmt.Println(tree.SExpr())
+		}
+	default:
+		panic("unreachable")
 	}
 }
 
-func VerifyThatAllSymbolsDefined(grammar map[string]Rule) (ok bool) {
-	ok = true
-	for _, expr := range grammar {
-		if !VerifyThatAllSymbolsDefinedInExpr(grammar, expr.Body) {
+// Reports whether all of the inputs match. Prints the parse trees of the matched inputs in the
+// treeFormat if it is not empty.
+func Match(grammar *bnf.Grammar, entry string, filePaths []string, bytes bool, treeFormat string, trees string) bool {
+	rec, err := bnf.NewRecognizer(grammar, entry)
+	if err != nil {
+		ReportErrors(err.(bnf.Errors))
+		return false
+	}
+	rec.Bytes = bytes
+	if len(filePaths) == 0 {
+		filePaths = []string{"-"}
+	} else if filePaths, err = ExpandInputs(filePaths); err != nil {
+		ReportError(err)
+		return false
+	}
+	ok := true
+	for _, filePath := range filePaths {
+		input, err := ReadInput(filePath, bytes)
+		if err != nil {
+			ReportError(err)
 			ok = false
+			continue
 		}
+		name := filePath
+		if name == "-" {
+			name = "<stdin>"
+		}
+		chart, err := rec.Match(input, name)
+		if err != nil {
+			ReportError(err)
+			ok = false
+			continue
+		}
+		if len(treeFormat) > 0 {
+			PrintTrees(rec, chart, name, treeFormat, trees)
+			continue
+		}
+		fmt.Printf("%s: OK\n", name)
 	}
-	return
+	return ok
 }
 
-func WalkSymbolsInExpr(grammar map[string]Rule, expr Expr, visited map[string]bool) (err error) {
-	switch expr := expr.(type) {
-	case ExprSymbol:
-		if !visited[expr.Name] {
-			visited[expr.Name] = true
-			rule, exists := grammar[expr.Name]
-			if !exists {
-				err = &DiagErr{
-					Loc: expr.Loc,
-					Err: fmt.Errorf("Symbol <%s> is not defined", expr.Name),
-				}
-				return
-			}
-			err = WalkSymbolsInExpr(grammar, rule.Body, visited)
-			if err != nil {
-				return
+// The files of the directories are read as well, so a whole corpus can be passed
+func ExpandInputs(filePaths []string) (result []string, err error) {
+	for _, filePath := range filePaths {
+		var info os.FileInfo
+		info, err = os.Stat(filePath)
+		if err != nil {
+			return
+		}
+		if !info.IsDir() {
+			result = append(result, filePath)
+			continue
+		}
+		var entries []os.DirEntry
+		entries, err = os.ReadDir(filePath)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				result = append(result, filepath.Join(filePath, entry.Name()))
 			}
 		}
+	}
+	return
+}
+
+// Reports the seeds that don't match the entry and skips them. ok is false if none of the seeds match.
+func NewMutator(grammar *bnf.Grammar, generator *bnf.Generator, entry string, filePaths []string, bytes bool, growth int) (mutator *bnf.Mutator, ok bool) {
+	rec, err := bnf.NewRecognizer(grammar, entry)
+	if err != nil {
+		ReportErrors(err.(bnf.Errors))
 		return
-	case ExprString:
+	}
+	rec.Bytes = bytes
+	mutator = bnf.NewMutator(generator, rec)
+	mutator.Growth = growth
+	if len(filePaths) == 0 {
+		filePaths = []string{"-"}
+	} else if filePaths, err = ExpandInputs(filePaths); err != nil {
+		ReportError(err)
 		return
-	case ExprAlternation:
-		for i := range expr.Variants {
-			err = WalkSymbolsInExpr(grammar, expr.Variants[i], visited)
-			if err != nil {
-				return
-			}
+	}
+	for _, filePath := range filePaths {
+		input, err := ReadInput(filePath, bytes)
+		if err != nil {
+			ReportError(err)
+			continue
+		}
+		name := filePath
+		if name == "-" {
+			name = "<stdin>"
 		}
+		if err = mutator.AddSeed(input, name); err != nil {
+			ReportError(err)
+		}
+	}
+	if len(mutator.Seeds) == 0 {
+		ReportError(fmt.Errorf("None of the seeds match the -entry symbol %s", entry))
 		return
-	case ExprConcat:
-		for i := range expr.Elements {
-			err = WalkSymbolsInExpr(grammar, expr.Elements[i], visited)
-			if err != nil {
+	}
+	ok = true
+	return
+}
+
+// The operators are separated by commas, all means all of them
+func NewNegativeGenerator(grammar *bnf.Grammar, generator *bnf.Generator, entry string, operators string, bytes bool) (neg *bnf.NegativeGenerator, ok bool) {
+	neg = &bnf.NegativeGenerator{
+		Generator: generator,
+	}
+	if operators == "all" {
+		neg.Operators = bnf.NegativeOps
+	} else {
+		for _, op := range strings.Split(operators, ",") {
+			if !Contains(bnf.NegativeOps, op) {
+				fmt.Fprintf(os.Stderr, "ERROR: unknown mutation operator %s\n", op)
+				flag.Usage()
 				return
 			}
+			neg.Operators = append(neg.Operators, op)
 		}
-		return
-	case ExprRepetition:
-		return WalkSymbolsInExpr(grammar, expr.Body, visited)
-	case ExprRange:
+	}
+	rec, err := bnf.NewRecognizer(grammar, entry)
+	if err != nil {
+		ReportErrors(err.(bnf.Errors))
 		return
 	}
-	panic(fmt.Sprintf("unreachable: %T", expr))
-}
-
-type Rule struct {
-	Head Token
-	Body Expr
+	rec.Bytes = bytes
+	neg.Recognizer = rec
+	ok = true
+	return
 }
 
-func (rule Rule) String() string {
-	sep := ""
-	for i := range LiteralTokens {
-		if LiteralTokens[i].Kind == TokenDefinition {
-			sep = LiteralTokens[i].Text
-			break
-		}
+// Reports whether the input was minimized. The input has to match the entry and the target has to fail on it.
+func Minimize(grammar *bnf.Grammar, entry string, filePaths []string, bytes bool, harness *Harness) bool {
+	if len(filePaths) > 1 {
+		ReportError(fmt.Errorf("-minimize expects a single input but got %d", len(filePaths)))
+		return false
 	}
-	if len(sep) == 0 {
-		// This should be possible to check at compile time in 2023
-		panic("Not a single TokenAlternation exists to render ExprAlternation")
+	filePath := "-"
+	if len(filePaths) == 1 {
+		filePath = filePaths[0]
+	}
+	name := filePath
+	if name == "-" {
+		name = "<stdin>"
+	}
+	input, err := ReadInput(filePath, bytes)
+	if err != nil {
+		ReportError(err)
+		return false
+	}
+	encode := func(message []rune) []byte {
+		if bytes {
+			return bnf.Octets(message)
+		}
+		return []byte(string(message))
 	}
 
-	sb := strings.Builder{}
-	sb.WriteString(string(rule.Head.Text))
-	sb.WriteString(" "+sep+" ")
-	sb.WriteString(rule.Body.String())
-	return sb.String()
+	rec, err := bnf.NewRecognizer(grammar, entry)
+	if err != nil {
+		ReportErrors(err.(bnf.Errors))
+		return false
+	}
+	rec.Bytes = bytes
+	if _, err = rec.Match(input, name); err != nil {
+		ReportError(err)
+		return false
+	}
+	original, err := harness.Exec(encode(input))
+	if err != nil {
+		ReportError(err)
+		return false
+	}
+	if original.Class == ClassOk {
+		ReportError(fmt.Errorf("The target does not fail on %s", name))
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", name, original)
+	// Only the failures of the same kind count, so the reduction doesn't slip into another bug
+	reducer := bnf.NewReducer(rec, func(candidate []rune) bool {
+		result, err := harness.Exec(encode(candidate))
+		return err == nil && result.String() == original.String()
+	})
+	result, err := reducer.Reduce(input, name)
+	if err != nil {
+		ReportError(err)
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Reduced %d bytes to %d bytes in %d executions\n", len(encode(input)), len(encode(result)), reducer.Tests)
+	os.Stdout.Write(encode(result))
+	return true
 }
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
 	filePath := flag.String("file", "", "Path to the BNF file")
 	entry := flag.String("entry", "", "The symbol name to start generating from. Passing '!' as the symbol name lists all of the available symbols in the -file.")
 	count := flag.Int("count", 1, "How many messages to generate")
 	verify := flag.Bool("verify", false, "Verify that all the symbols are defined")
 	unused := flag.Bool("unused", false, "Verify that all the symbols are used")
 	dump := flag.Bool("dump", false, "Dump the text representation of -entry symbol")
+	useCore := flag.Bool("core", false, "Provide the RFC 5234 core rules (ALPHA, DIGIT, CRLF, ...) which can be shadowed by the rules of the -file. By default they are provided only for the abnf syntax and the mix of BNF and ABNF.")
+	syntaxName := flag.String("syntax", "auto", "The syntax of the -file: bnf, abnf, ebnf, w3c or auto. auto picks the syntax by the file extension and falls back to the mix of BNF and ABNF.")
+	maxDepth := flag.Int("max-depth", bnf.DefaultMaxDepth, "How deep the symbols may be nested before the generator starts picking the shortest ways to finish the message. 0 means no limit.")
+	maxSize := flag.Int("max-size", bnf.DefaultMaxSize, "How many characters a message may have before the generator starts picking the shortest ways to finish it. 0 means no limit.")
+	maxRepetitions := flag.Uint("max-repetitions", bnf.MaxUnspecifiedUpperRepetitionBound, "The upper bound of the repetitions that don't specify it, like *a or {a}")
+	repetitions := flag.String("repetitions", "uniform", "The distribution of the amounts of repetitions: uniform, geometric:MEAN, poisson:MEAN or bounds:PROBABILITY. bounds picks one of the bounds with the PROBABILITY and a uniform amount otherwise.")
+	profilePath := flag.String("profile", "", "Path to a JSON file with the weights of the alternatives and the repetitions of the rules of the -file")
+	seed := flag.Int64("seed", 0, "The seed of the run. The seeds of the messages are derived from it. The current time is used if not provided.")
+	seedsPath := flag.String("seeds-file", "", "Path to the file to write the seed of every generated message to, one per line. Pass /dev/stderr to print them to stderr.")
+	replaySeed := flag.Int64("replay-seed", 0, "Regenerate the single message with the seed reported by -seeds-file")
+	bytes := flag.Bool("bytes", false, "Emit every value of the messages as a single raw octet instead of UTF-8, for the binary protocols. The values that don't fit in a byte are reported as errors.")
+	match := flag.Bool("match", false, "Instead of generating check that the files passed after the flags, files or directories of files, or stdin if there are none, are derived from the -entry symbol. With -bytes every byte of the input is a separate value.")
+	negative := flag.String("negative", "", fmt.Sprintf("Generate the messages that almost match the -entry symbol by applying one of the comma separated mutation operators to every message: %s or all. The messages that still match are thrown away.", strings.Join(bnf.NegativeOps, ", ")))
+	mutate := flag.Bool("mutate", false, "Instead of generating from scratch mutate the seed inputs passed after the flags, files or directories of files, that match the -entry symbol. The mutants replace the parts of the seeds derived from a rule with a random message of the same rule or with the parts of the seeds derived from the same rule.")
+	mutateGrowth := flag.Int("mutate-growth", bnf.DefaultMutationGrowth, fmt.Sprintf("How many times longer than the replaced part of a seed the random message of -mutate may be, but at least %d characters. 0 means only -max-size limits it.", bnf.MinRegeneratedSize))
+	run := flag.Bool("run", false, "Execute the target command passed after -- for every message instead of printing it. The message goes to stdin of the target, or to a temporary file if any of the arguments is @@ which is replaced with the path to the file. The messages the target fails on are saved into -crashes.")
+	minimize := flag.Bool("minimize", false, "Shrink the input passed after the flags, or stdin, while the target command passed after -- still fails on it the same way as on the input. The target is executed like with -run. The shrunk input is printed to stdout.")
+	timeout := flag.Duration("timeout", time.Second, "How long the -run target may take per message before it is killed and reported as a hang. 0 means no limit.")
+	workers := flag.Int("workers", runtime.NumCPU(), "How many -run targets are executed in parallel")
+	crashDir := flag.String("crashes", "crashes", "Path to the directory to save the messages the -run target fails on into")
+	okExitCodesText := flag.String("ok-exit-codes", "0", "The comma separated exit codes of the -run target that are not failures")
+	treeFormat := flag.String("tree", "", fmt.Sprintf("Print the parse trees of the inputs matched by -match in the format: %s", strings.Join(TreeFormats, ", ")))
+	trees := flag.String("trees", "first", fmt.Sprintf("Which parse trees of the ambiguous inputs -tree prints: %s", strings.Join(TreeModes, ", ")))
+	framing := flag.String("framing", "none", fmt.Sprintf("How the messages are separated in the output: %s. nul appends the NUL byte after every message, length prefixes every message with its length in bytes as a 32-bit big-endian integer, jsonl prints every message with its seed as a JSON object per line.", strings.Join(Framings, ", ")))
+	separatorText := flag.String("separator", "", "The separator appended after every message with -framing none. Supports the escape sequences of Go strings like \\n or \\x00.")
+	corpusDir := flag.String("corpus", "", "Path to the directory to write every message into its own file, for example the corpus of AFL++ or libFuzzer")
+	manifestPath := flag.String("manifest", "", "Path to the JSON Lines file with the file names and the seeds of the messages of -corpus. Defaults to the -corpus path with the .manifest.jsonl suffix.")
+	diagFormat := flag.String("diagnostics-format", "text", fmt.Sprintf("The format of the diagnostics printed to stderr: %s", strings.Join(DiagFormats, ", ")))
 	flag.Parse()
-	if len(*filePath) == 0 {
-		fmt.Fprintf(os.Stderr, "ERROR: -file is not provided\n")
+	validDiagFormat := false
+	for _, format := range DiagFormats {
+		if format == *diagFormat {
+			validDiagFormat = true
+		}
+	}
+	if !validDiagFormat {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown diagnostics format %s\n", *diagFormat)
 		flag.Usage()
 		os.Exit(1)
 	}
-	if len(*entry) == 0 {
-		fmt.Fprintf(os.Stderr, "ERROR: -entry is not provided\n")
+	DiagFormat = *diagFormat
+	validFraming := false
+	for _, name := range Framings {
+		if name == *framing {
+			validFraming = true
+		}
+	}
+	if !validFraming {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown framing %s\n", *framing)
 		flag.Usage()
 		os.Exit(1)
 	}
-	content, err := os.ReadFile(*filePath)
+	separator, err := ParseSeparator(*separatorText)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		flag.Usage()
 		os.Exit(1)
 	}
-	grammar := map[string]Rule{}
-	parsingError := false
-	for row, line := range strings.Split(string(content), "\n") {
-		lexer := NewLexer(line, *filePath, row)
-
-		token, err := lexer.Peek()
-		if err == nil && token.Kind == TokenEOL {
-			continue
-		}
-
-		var head Token
-		head, err = ExpectToken(&lexer, TokenSymbol)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
-			parsingError = true
-			continue
-		}
-
-		var def Token
-		def, err = lexer.Next()
+	if len(separator) > 0 && *framing != "none" {
+		fmt.Fprintf(os.Stderr, "ERROR: -separator can only be used with -framing none\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if len(*corpusDir) > 0 && (len(separator) > 0 || *framing != "none") {
+		fmt.Fprintf(os.Stderr, "ERROR: -framing and -separator can not be used with -corpus, every message goes into its own file\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if len(*manifestPath) > 0 && len(*corpusDir) == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: -manifest can only be used with -corpus\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	defer FlushDiagnostics()
+	if len(*filePath) == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: -file is not provided\n")
+		flag.Usage()
+		Exit(1)
+	}
+	if len(*entry) == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: -entry is not provided\n")
+		flag.Usage()
+		Exit(1)
+	}
+	flags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = true
+	})
+	options := bnf.Options{
+		NoCore: flags["core"] && !*useCore,
+		Core: *useCore,
+	}
+	if flags["max-repetitions"] {
+		options.MaxRepetitions = maxRepetitions
+	}
+	if *repetitions != "uniform" {
+		var err error
+		options.Repetitions, err = bnf.ParseDistribution(*repetitions)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
-			parsingError = true
-			continue
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			flag.Usage()
+			Exit(1)
 		}
-
-		symbol := string(head.Text)
-		existingRule, ruleExists := grammar[symbol]
-
-		switch def.Kind {
-		case TokenDefinition:
-			if ruleExists {
-				fmt.Fprintf(os.Stderr, "%s: ERROR: redefinition of the rule %s\n", head.Loc, symbol)
-				fmt.Fprintf(os.Stderr, "%s: NOTE: the first definition is located here\n", existingRule.Head.Loc)
-				parsingError = true
-				continue
-			}
-
-			var body Expr
-			body, err = ParseExpr(&lexer)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s\n", err)
-				parsingError = true
-				continue
-			}
-
-			grammar[symbol] = Rule{
-				Head: head,
-				Body: body,
-			}
-
-		case TokenIncAlternative:
-			if !ruleExists {
-				fmt.Fprintf(os.Stderr, "%s: ERROR: can't apply incremental alternative to a non-existing rule %s. You need to define it first.\n", head.Loc, symbol)
-				parsingError = true
-				continue
-			}
-
-			var body Expr
-			body, err = ParseExpr(&lexer)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s\n", err)
-				parsingError = true
-				continue
-			}
-
-			switch existingBody := existingRule.Body.(type) {
-			case ExprAlternation:
-				existingBody.Variants = append(existingBody.Variants, body)
-				existingRule.Body = existingBody
-			default:
-				existingRule.Body = ExprAlternation{
-					Loc: existingBody.GetLoc(),
-					Variants: []Expr{
-						existingBody,
-						body,
-					},
-				}
-			}
-
-			grammar[symbol] = existingRule
-		default:
-			fmt.Fprintf(os.Stderr, "%s\n", &DiagErr{
-				Loc: def.Loc,
-				Err: fmt.Errorf("Expected %s or %s but got %s",
-					TokenKindName[TokenDefinition], TokenKindName[TokenIncAlternative],
-					TokenKindName[def.Kind]),
-			})
-			parsingError = true
-			continue
+	}
+	if *syntaxName != "auto" {
+		var ok bool
+		options.Syntax, ok = bnf.Syntaxes[*syntaxName]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "ERROR: unknown syntax %s\n", *syntaxName)
+			flag.Usage()
+			Exit(1)
 		}
+	}
+	grammar, err := bnf.ParseFile(*filePath, options)
+	if err != nil {
+		ReportErrors(err.(bnf.Errors))
+		Exit(1)
+	}
 
-		_, err = ExpectToken(&lexer, TokenEOL)
+	if len(*profilePath) > 0 {
+		profile, err := bnf.LoadProfile(*profilePath)
 		if err != nil {
-			fmt.Fprintf(os. Stderr, "%s\n", err)
-			parsingError = true
-			continue
+			ReportError(err)
+			Exit(1)
+		}
+		if errs := grammar.ApplyProfile(profile); len(errs) > 0 {
+			ReportErrors(errs)
+			Exit(1)
 		}
 	}
 
-	if parsingError {
-		os.Exit(1)
+	if *bytes {
+		if errs := grammar.VerifyBytes(); len(errs) > 0 {
+			ReportErrors(errs)
+			Exit(1)
+		}
 	}
 
 	if *verify {
-		ok := VerifyThatAllSymbolsDefined(grammar)
-		if !ok {
-			os.Exit(1)
+		errs := grammar.Verify()
+		ReportErrors(errs)
+		if errs.HasErrors() {
+			Exit(1)
 		}
 	}
 
 	if *entry == "!" {
-		names := []string{}
-		for name := range grammar {
-			names = append(names, name)
-		}
-		sort.Strings(names)
+		names := grammar.Names()
 
 		if *dump {
 			for i := range names {
-				rule := grammar[names[i]]
+				rule := grammar.Rules[names[i]]
+				fmt.Printf("%s: %s\n", rule.Head.Loc, rule.String())
+			}
+			if len(grammar.CoreNames) > 0 {
+				fmt.Printf("; RFC 5234 core rules\n")
+			}
+			for i := range grammar.CoreNames {
+				rule := grammar.Rules[grammar.CoreNames[i]]
 				fmt.Printf("%s: %s\n", rule.Head.Loc, rule.String())
 			}
 			return
@@ -345,29 +507,33 @@ func main() {
 		for i := range names {
 			fmt.Println(names[i])
 		}
+		if len(grammar.CoreNames) > 0 {
+			fmt.Printf("; RFC 5234 core rules\n")
+		}
+		for i := range grammar.CoreNames {
+			fmt.Println(grammar.CoreNames[i])
+		}
 		return
 	}
 
-	rule, ok := grammar[*entry]
+	rule, ok := grammar.Rules[*entry]
 	if !ok {
-		fmt.Printf("ERROR: Symbol %s is not defined. Pass -entry '!' to get the list of defined symbols.\n", *entry)
-		os.Exit(1)
+		help := bnf.SymbolHelp(grammar.Rules, *entry)
+		if len(help) == 0 {
+			help = "pass -entry '!' to get the list of defined symbols"
+		}
+		ReportError(&bnf.DiagErr{
+			Code: bnf.CodeUndefinedSymbol,
+			Err: fmt.Errorf("Symbol %s is not defined", *entry),
+			Help: help,
+		})
+		Exit(1)
 	}
 
 	if *unused {
-		visited := map[string]bool{}
-		visited[*entry] = true
-		err = WalkSymbolsInExpr(grammar, rule.Body, visited)
-
-		ok := true
-		for name := range grammar {
-			if !visited[name] {
-				fmt.Fprintf(os.Stderr, "%s: %s is unused\n", grammar[name].Head.Loc, name)
-				ok = false
-			}
-		}
-		if !ok {
-			os.Exit(1)
+		if errs := grammar.Unused(*entry); len(errs) > 0 {
+			ReportErrors(errs)
+			Exit(1)
 		}
 	}
 
@@ -376,12 +542,241 @@ func main() {
 		return
 	}
 
+	if errs := grammar.VerifyExceptions(*entry); len(errs) > 0 {
+		ReportErrors(errs)
+		Exit(1)
+	}
+
+	if *match && *mutate {
+		fmt.Fprintf(os.Stderr, "ERROR: -match and -mutate can not be used together\n")
+		flag.Usage()
+		Exit(1)
+	}
+	inputs, command := flag.Args(), []string{}
+	if *run || *minimize {
+		inputs, command = nil, flag.Args()
+		if *mutate || *minimize {
+			inputs, command = SplitCommand(os.Args[1:], flag.Args())
+		}
+		if len(command) == 0 {
+			fmt.Fprintf(os.Stderr, "ERROR: the -run target command is not provided after --\n")
+			flag.Usage()
+			Exit(1)
+		}
+		if _, err := exec.LookPath(command[0]); err != nil {
+			ReportError(err)
+			Exit(1)
+		}
+		if *match {
+			fmt.Fprintf(os.Stderr, "ERROR: -match can not be used with -run or -minimize\n")
+			flag.Usage()
+			Exit(1)
+		}
+		if len(*corpusDir) > 0 {
+			fmt.Fprintf(os.Stderr, "ERROR: -corpus can not be used with -run or -minimize, the failures are saved into -crashes\n")
+			flag.Usage()
+			Exit(1)
+		}
+	}
+	okExitCodes, err := ParseExitCodes(*okExitCodesText)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		flag.Usage()
+		Exit(1)
+	}
+	if *minimize {
+		harness := &Harness{
+			Command: command,
+			Timeout: *timeout,
+			OkExitCodes: okExitCodes,
+		}
+		if !Minimize(grammar, *entry, inputs, *bytes, harness) {
+			Exit(1)
+		}
+		return
+	}
+	if *match {
+		if !Contains(TreeFormats, *treeFormat) && len(*treeFormat) > 0 {
+			fmt.Fprintf(os.Stderr, "ERROR: unknown tree format %s\n", *treeFormat)
+			flag.Usage()
+			Exit(1)
+		}
+		if !Contains(TreeModes, *trees) {
+			fmt.Fprintf(os.Stderr, "ERROR: unknown trees mode %s\n", *trees)
+			flag.Usage()
+			Exit(1)
+		}
+		if !Match(grammar, *entry, inputs, *bytes, *treeFormat, *trees) {
+			Exit(1)
+		}
+		return
+	}
+
+	if !flags["seed"] {
+		*seed = time.Now().UnixNano()
+	}
+	generator := bnf.NewGenerator(grammar, *seed)
+	generator.MaxDepth = *maxDepth
+	generator.MaxSize = *maxSize
+	generator.Bytes = *bytes
+	encode := func(message []rune) []byte {
+		if *bytes {
+			return bnf.Octets(message)
+		}
+		return []byte(string(message))
+	}
+
+	var harness *Harness
+	var jobs chan RunJob
+	var harnessOk chan bool
+	if *run {
+		harness = &Harness{
+			Command: command,
+			Timeout: *timeout,
+			Workers: *workers,
+			CrashDir: *crashDir,
+			OkExitCodes: okExitCodes,
+		}
+		if harness.Workers < 1 {
+			harness.Workers = 1
+		}
+		jobs = make(chan RunJob, harness.Workers)
+		harnessOk = make(chan bool)
+		go func() {
+			harnessOk <- harness.Run(jobs)
+		}()
+	}
+	var output Output = &StreamOutput{
+		Writer: os.Stdout,
+		Framing: *framing,
+		Separator: separator,
+		Bytes: *bytes,
+	}
+	if len(*corpusDir) > 0 {
+		output, err = NewCorpusOutput(*corpusDir, *manifestPath)
+		if err != nil {
+			ReportError(err)
+			Exit(1)
+		}
+	}
+	// os.Exit does not run the deferred calls, so the outputs are closed explicitly before exiting,
+	// otherwise the -corpus manifest may be left incomplete
+	closers := []io.Closer{output}
+	exit := func(code int) {
+		for _, closer := range closers {
+			if err := closer.Close(); err != nil {
+				ReportError(err)
+				code = 1
+			}
+		}
+		Exit(code)
+	}
+
+	// Waits for the harness to finish the messages that are already generated
+	finish := func() {
+		if harness == nil {
+			return
+		}
+		close(jobs)
+		if !<-harnessOk {
+			exit(1)
+		}
+	}
+
+	next := func() (message []rune, info MessageInfo, err error) {
+		message, info.Seed, err = generator.Next(*entry)
+		return
+	}
+	replay := func(seed int64) ([]rune, *bnf.NegativeLabel, error) {
+		message, err := generator.GenerateWithSeed(*entry, seed)
+		return message, nil, err
+	}
+	if *mutate {
+		mutator, ok := NewMutator(grammar, generator, *entry, inputs, *bytes, *mutateGrowth)
+		if !ok {
+			exit(1)
+		}
+		next = func() (message []rune, info MessageInfo, err error) {
+			message, info.Seed, err = mutator.Next()
+			return
+		}
+		replay = func(seed int64) ([]rune, *bnf.NegativeLabel, error) {
+			message, err := mutator.MutateWithSeed(seed)
+			return message, nil, err
+		}
+	}
+	if len(*negative) > 0 {
+		if *mutate {
+			fmt.Fprintf(os.Stderr, "ERROR: -negative and -mutate can not be used together\n")
+			flag.Usage()
+			exit(1)
+		}
+		neg, ok := NewNegativeGenerator(grammar, generator, *entry, *negative, *bytes)
+		if !ok {
+			exit(1)
+		}
+		next = func() (message []rune, info MessageInfo, err error) {
+			message, info.Seed, info.Label, err = neg.Next(*entry)
+			return
+		}
+		replay = func(seed int64) (message []rune, label *bnf.NegativeLabel, err error) {
+			message, label, err = neg.GenerateWithSeed(*entry, seed)
+			if err == nil && label == nil {
+				err = fmt.Errorf("The seed %d does not produce an invalid message", seed)
+			}
+			return
+		}
+	}
+
+	if flags["replay-seed"] {
+		message, label, err := replay(*replaySeed)
+		if err != nil {
+			ReportError(err)
+			exit(1)
+		}
+		if harness != nil {
+			jobs <- RunJob{Message: encode(message), Info: MessageInfo{Seed: *replaySeed, Label: label}}
+			finish()
+			exit(0)
+		}
+		if err = output.Write(encode(message), MessageInfo{Seed: *replaySeed, Label: label}); err != nil {
+			ReportError(err)
+			exit(1)
+		}
+		exit(0)
+	}
+
+	var seeds *os.File
+	if len(*seedsPath) > 0 {
+		seeds, err = os.Create(*seedsPath)
+		if err != nil {
+			ReportError(err)
+			exit(1)
+		}
+		closers = append(closers, seeds)
+	}
 	for i := 0; i < *count; i += 1 {
-		message, err := GenerateRandomMessage(grammar, rule.Body)
+		message, info, err := next()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
-			os.Exit(1)
+			ReportError(err)
+			exit(1)
+		}
+		if seeds != nil {
+			if info.Label != nil {
+				fmt.Fprintf(seeds, "%d\t%s\t%s\t%s\n", info.Seed, info.Label.Operator, info.Label.Rule, info.Label.Loc)
+			} else {
+				fmt.Fprintf(seeds, "%d\n", info.Seed)
+			}
+		}
+		if harness != nil {
+			jobs <- RunJob{Message: encode(message), Info: info}
+			continue
+		}
+		if err = output.Write(encode(message), info); err != nil {
+			ReportError(err)
+			exit(1)
 		}
-		fmt.Print(string(message))
 	}
+	finish()
+	exit(0)
 }
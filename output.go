@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/rexim/bnfuzzer/bnf"
+)
+
+var Framings = []string{"none", "nul", "length", "jsonl"}
+
+// What is known about a generated message besides its content
+type MessageInfo struct {
+	Seed int64
+	// Set for the negative messages, see bnf.NegativeLabel
+	Label *bnf.NegativeLabel
+}
+
+// The label fields of the JSON outputs
+type LabelJson struct {
+	Operator string `json:"operator,omitempty"`
+	Rule string `json:"rule,omitempty"`
+	Loc string `json:"loc,omitempty"`
+}
+
+func (info MessageInfo) LabelJson() (label LabelJson) {
+	if info.Label != nil {
+		label.Operator = info.Label.Operator
+		label.Rule = info.Label.Rule
+		label.Loc = info.Label.Loc.String()
+	}
+	return
+}
+
+// Where the generated messages go
+type Output interface {
+	Write(message []byte, info MessageInfo) error
+	Close() error
+}
+
+// Writes the messages into a single stream separating them according to the Framing
+type StreamOutput struct {
+	Writer io.Writer
+	Framing string
+	// Appended after every message with the "none" Framing
+	Separator []byte
+	// The messages are raw octets. JSON can't represent them directly, so every octet
+	// becomes the code point with the same value in the "jsonl" Framing.
+	Bytes bool
+}
+
+type JsonLine struct {
+	Message string `json:"message"`
+	Seed int64 `json:"seed"`
+	LabelJson
+}
+
+func (output *StreamOutput) Write(message []byte, info MessageInfo) (err error) {
+	frame := []byte{}
+	switch output.Framing {
+	case "none":
+		frame = append(frame, message...)
+		frame = append(frame, output.Separator...)
+	case "nul":
+		frame = append(frame, message...)
+		frame = append(frame, 0)
+	case "length":
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(message)))
+		frame = append(frame, message...)
+	case "jsonl":
+		text := string(message)
+		if output.Bytes {
+			runes := make([]rune, len(message))
+			for i, x := range message {
+				runes[i] = rune(x)
+			}
+			text = string(runes)
+		}
+		// The grammars are full of <, > and &, so they are not escaped like in HTML
+		buffer := bytes.Buffer{}
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		err = encoder.Encode(JsonLine{
+			Message: text,
+			Seed: info.Seed,
+			LabelJson: info.LabelJson(),
+		})
+		if err != nil {
+			return
+		}
+		frame = buffer.Bytes()
+	default:
+		panic("unreachable")
+	}
+	_, err = output.Writer.Write(frame)
+	return
+}
+
+func (output *StreamOutput) Close() error {
+	return nil
+}
+
+// Writes every message into its own file in the Dir, so the Dir can be used as the corpus of
+// AFL++ or libFuzzer. The file names and the seeds of the messages are listed in the Manifest.
+type CorpusOutput struct {
+	Dir string
+	Manifest *os.File
+	// The number of the next file
+	Count int
+}
+
+type ManifestLine struct {
+	File string `json:"file"`
+	Seed int64 `json:"seed"`
+	LabelJson
+}
+
+// The numbers of the files written by CorpusOutput. The numbers above 999999 take more digits.
+var CorpusFileRegexp = regexp.MustCompile(`^[0-9]{6,}$`)
+
+// The manifest is kept next to the Dir by default, so the fuzzers don't pick it up as an input.
+// The numbering continues after the files already in the Dir and the manifest is appended to, so
+// the runs with different seeds can fill the same corpus.
+func NewCorpusOutput(dir string, manifestPath string) (output *CorpusOutput, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	count := 0
+	for _, entry := range entries {
+		if !CorpusFileRegexp.MatchString(entry.Name()) {
+			continue
+		}
+		n, err := strconv.Atoi(entry.Name())
+		if err == nil && n >= count {
+			count = n + 1
+		}
+	}
+	if len(manifestPath) == 0 {
+		manifestPath = filepath.Clean(dir) + ".manifest.jsonl"
+	}
+	manifest, err := os.OpenFile(manifestPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	output = &CorpusOutput{
+		Dir: dir,
+		Manifest: manifest,
+		Count: count,
+	}
+	return
+}
+
+func (output *CorpusOutput) Write(message []byte, info MessageInfo) (err error) {
+	name := fmt.Sprintf("%06d", output.Count)
+	output.Count += 1
+	if err = os.WriteFile(filepath.Join(output.Dir, name), message, 0644); err != nil {
+		return
+	}
+	line, err := json.Marshal(ManifestLine{
+		File: name,
+		Seed: info.Seed,
+		LabelJson: info.LabelJson(),
+	})
+	if err != nil {
+		return
+	}
+	_, err = output.Manifest.Write(append(line, '\n'))
+	return
+}
+
+func (output *CorpusOutput) Close() error {
+	return output.Manifest.Close()
+}
+
+// The separator may contain the escape sequences of the Go strings like \n or \x00
+func ParseSeparator(text string) (separator []byte, err error) {
+	unquoted, err := strconv.Unquote("\"" + text + "\"")
+	if err != nil {
+		err = fmt.Errorf("invalid separator %s: %w", text, err)
+		return
+	}
+	separator = []byte(unquoted)
+	return
+}
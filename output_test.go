@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The next runs into the same corpus continue the numbering and the manifest instead of overwriting them
+func TestCorpusOutputContinues(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "corpus")
+	for run, messages := range [][]string{{"a", "b"}, {"c"}} {
+		output, err := NewCorpusOutput(dir, "")
+		if err != nil {
+			t.Fatalf("run %d: %s", run, err)
+		}
+		for i, message := range messages {
+			if err := output.Write([]byte(message), MessageInfo{Seed: int64(i)}); err != nil {
+				t.Fatalf("run %d: %s", run, err)
+			}
+		}
+		if err := output.Close(); err != nil {
+			t.Fatalf("run %d: %s", run, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a message"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1000000"), []byte("d"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	output, err := NewCorpusOutput(dir, "")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	output.Close()
+	if output.Count != 1000001 {
+		t.Fatalf("expected the numbering to continue from 1000001, got %d", output.Count)
+	}
+
+	expected := map[string]string{"000000": "a", "000001": "b", "000002": "c"}
+	for name, message := range expected {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(content) != message {
+			t.Fatalf("%s: expected %q, got %q (%v)", name, message, string(content), err)
+		}
+	}
+	manifest, err := os.ReadFile(dir + ".manifest.jsonl")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(manifest)), "\n")
+	files := []string{}
+	for _, line := range lines {
+		var manifestLine ManifestLine
+		if err := json.Unmarshal([]byte(line), &manifestLine); err != nil {
+			t.Fatalf("%q: %s", line, err)
+		}
+		files = append(files, manifestLine.File)
+	}
+	if !reflect.DeepEqual(files, []string{"000000", "000001", "000002"}) {
+		t.Fatalf("expected the manifest to list every file once, got %q", lines)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The outcomes of running the target on a message
+const (
+	ClassOk = "ok"
+	ClassExit = "exit"
+	ClassSignal = "signal"
+	ClassPanic = "panic"
+	ClassSanitizer = "sanitizer"
+	ClassTimeout = "timeout"
+)
+
+var GoPanicRegexp = regexp.MustCompile(`(?m)^(panic: |fatal error: )[\s\S]*goroutine \d+ \[`)
+var SanitizerRegexp = regexp.MustCompile(`(?m)^(==\d+==ERROR: \w+Sanitizer|SUMMARY: \w+Sanitizer|WARNING: ThreadSanitizer)|:\d+:\d+: runtime error: `)
+
+type RunResult struct {
+	Class string
+	ExitCode int
+	Signal string
+	Stderr []byte
+}
+
+func (result RunResult) String() string {
+	switch result.Class {
+	case ClassExit:
+		return fmt.Sprintf("exit code %d", result.ExitCode)
+	case ClassSignal:
+		return fmt.Sprintf("signal %s", result.Signal)
+	}
+	return result.Class
+}
+
+// Classifies how the target handled the message. The reports in stderr take precedence over the
+// exit code, because the Go panics and the sanitizers exit with their own codes. The exit code is
+// negative if the target was killed by the signal.
+func Classify(exitCode int, signal string, stderr []byte, timedOut bool, okExitCodes map[int]bool) (result RunResult) {
+	result.Stderr = stderr
+	result.ExitCode = exitCode
+	switch {
+	case timedOut:
+		result.Class = ClassTimeout
+	case SanitizerRegexp.Match(stderr):
+		result.Class = ClassSanitizer
+	case GoPanicRegexp.Match(stderr):
+		result.Class = ClassPanic
+	case result.ExitCode < 0:
+		result.Class = ClassSignal
+		result.Signal = signal
+	case !okExitCodes[result.ExitCode]:
+		result.Class = ClassExit
+	default:
+		result.Class = ClassOk
+	}
+	return
+}
+
+// The name of the signal that killed the process, empty if it exited on its own
+func SignalOf(state *os.ProcessState) string {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal().String()
+	}
+	return ""
+}
+
+// Feeds the messages to the target Command and saves the ones it fails on into the CrashDir
+type Harness struct {
+	// The "@@" arguments are replaced with the path to a file with the message. The message goes
+	// to stdin if there are none of them.
+	Command []string
+	Timeout time.Duration
+	Workers int
+	CrashDir string
+	OkExitCodes map[int]bool
+
+	mutex sync.Mutex
+	Execs int
+	Classes map[string]int
+	// How many times the target could not be executed at all
+	Errors int
+	Start time.Time
+}
+
+type RunJob struct {
+	Message []byte
+	Info MessageInfo
+}
+
+// The message and stderr go through the temporary files rather than the pipes, so the target is
+// not waited for after the timeout if it left a child process holding them open.
+func (harness *Harness) Exec(message []byte) (result RunResult, err error) {
+	file, err := os.CreateTemp("", "bnfuzzer-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err = file.Write(message); err != nil {
+		return
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	stderr, err := os.CreateTemp("", "bnfuzzer-stderr-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(stderr.Name())
+	defer stderr.Close()
+
+	args := append([]string{}, harness.Command...)
+	fileArg := false
+	for i := range args {
+		if args[i] == "@@" {
+			args[i] = file.Name()
+			fileArg = true
+		}
+	}
+
+	ctx := context.Background()
+	cancel := func() {}
+	if harness.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, harness.Timeout)
+	}
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if !fileArg {
+		cmd.Stdin = file
+	}
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return
+	}
+	output, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		return
+	}
+	result = Classify(cmd.ProcessState.ExitCode(), SignalOf(cmd.ProcessState), output, ctx.Err() == context.DeadlineExceeded, harness.OkExitCodes)
+	return
+}
+
+// Saves the message as <class>_<seed> with the stderr of the target next to it in <class>_<seed>.stderr.
+// The message can be regenerated with -replay-seed.
+func (harness *Harness) SaveCrash(job RunJob, result RunResult) (err error) {
+	if err = os.MkdirAll(harness.CrashDir, 0755); err != nil {
+		return
+	}
+	name := filepath.Join(harness.CrashDir, fmt.Sprintf("%s_%d", result.Class, job.Info.Seed))
+	if err = os.WriteFile(name, job.Message, 0644); err != nil {
+		return
+	}
+	return os.WriteFile(name + ".stderr", result.Stderr, 0644)
+}
+
+func (harness *Harness) Stats() string {
+	harness.mutex.Lock()
+	defer harness.mutex.Unlock()
+	elapsed := time.Since(harness.Start).Seconds()
+	classes := []string{}
+	failures := 0
+	for class, n := range harness.Classes {
+		if class != ClassOk {
+			classes = append(classes, fmt.Sprintf("%s: %d", class, n))
+			failures += n
+		}
+	}
+	sort.Strings(classes)
+	stats := fmt.Sprintf("execs: %d, execs/sec: %.1f, failures: %d", harness.Execs, float64(harness.Execs)/elapsed, failures)
+	if len(classes) > 0 {
+		stats += fmt.Sprintf(" (%s)", strings.Join(classes, ", "))
+	}
+	return stats
+}
+
+// Runs the jobs on the Workers in parallel printing the stats to stderr every second. Reports whether
+// the target handled all of the messages.
+func (harness *Harness) Run(jobs <-chan RunJob) bool {
+	harness.Start = time.Now()
+	harness.Classes = map[string]int{}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(os.Stderr, "%s\n", harness.Stats())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < harness.Workers; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				result, err := harness.Exec(job.Message)
+				if err != nil {
+					harness.mutex.Lock()
+					harness.Errors += 1
+					ReportError(err)
+					harness.mutex.Unlock()
+					continue
+				}
+				harness.mutex.Lock()
+				harness.Execs += 1
+				harness.Classes[result.Class] += 1
+				harness.mutex.Unlock()
+				if result.Class == ClassOk {
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "%s: seed %d\n", result, job.Info.Seed)
+				if err = harness.SaveCrash(job, result); err != nil {
+					harness.mutex.Lock()
+					ReportError(err)
+					harness.mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	fmt.Fprintf(os.Stderr, "%s\n", harness.Stats())
+	return harness.Errors == 0 && harness.Classes[ClassOk] == harness.Execs
+}
+
+// Splits the arguments after the flags into the inputs and the target command after "--". Only the
+// modes that take the inputs should split them, so the command may have its own "--". The rawArgs
+// are all of the arguments of the program without its name, like os.Args[1:].
+func SplitCommand(rawArgs []string, args []string) (inputs []string, command []string) {
+	if FlagsTerminated(rawArgs, args) {
+		return nil, args
+	}
+	for i := range args {
+		if args[i] == "--" {
+			return args[:i], args[i + 1:]
+		}
+	}
+	return nil, args
+}
+
+// The flag package drops the "--" if it goes right after the flags, so all of the args are the
+// command then
+func FlagsTerminated(rawArgs []string, args []string) bool {
+	i := len(rawArgs) - len(args) - 1
+	return i >= 0 && rawArgs[i] == "--"
+}
+
+func ParseExitCodes(text string) (codes map[int]bool, err error) {
+	codes = map[int]bool{}
+	for _, field := range strings.Split(text, ",") {
+		var code int
+		if code, err = strconv.Atoi(strings.TrimSpace(field)); err != nil {
+			err = fmt.Errorf("invalid exit code %s", field)
+			return
+		}
+		codes[code] = true
+	}
+	return
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const GoPanic = "panic: runtime error: index out of range [3] with length 3\n\ngoroutine 1 [running]:\nmain.main()\n"
+const Sanitizer = "==42==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000011\n"
+
+// The reports in stderr win over the way the target exited: sanitizer > panic > signal > exit code
+func TestClassify(t *testing.T) {
+	okExitCodes := map[int]bool{0: true}
+	tests := []struct {
+		name string
+		exitCode int
+		signal string
+		stderr string
+		timedOut bool
+		class string
+	}{
+		{"ok", 0, "", "", false, ClassOk},
+		{"exit", 3, "", "", false, ClassExit},
+		{"signal", -1, "segmentation fault", "", false, ClassSignal},
+		{"panic over exit", 2, "", GoPanic, false, ClassPanic},
+		{"panic over signal", -1, "aborted", GoPanic, false, ClassPanic},
+		{"sanitizer over exit", 1, "", Sanitizer, false, ClassSanitizer},
+		{"sanitizer over signal", -1, "aborted", Sanitizer, false, ClassSanitizer},
+		{"sanitizer over panic", 2, "", Sanitizer + GoPanic, false, ClassSanitizer},
+		{"undefined behavior sanitizer", 1, "", "test.c:3:5: runtime error: signed integer overflow\n", false, ClassSanitizer},
+		{"timeout over everything", -1, "killed", Sanitizer + GoPanic, true, ClassTimeout},
+		{"panic with ok exit", 0, "", GoPanic, false, ClassPanic},
+	}
+	for _, test := range tests {
+		result := Classify(test.exitCode, test.signal, []byte(test.stderr), test.timedOut, okExitCodes)
+		if result.Class != test.class {
+			t.Errorf("%s: expected %s, got %s", test.name, test.class, result.Class)
+		}
+		if result.Class == ClassSignal && result.Signal != test.signal {
+			t.Errorf("%s: expected the signal %s, got %s", test.name, test.signal, result.Signal)
+		}
+	}
+
+	if result := Classify(3, "", nil, false, map[int]bool{0: true, 3: true}); result.Class != ClassOk {
+		t.Errorf("expected the exit code 3 to be ok, got %s", result.Class)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		rawArgs []string
+		args []string
+		inputs []string
+		command []string
+	}{
+		{"inputs", []string{"-mutate", "a", "b", "--", "./target", "@@"}, []string{"a", "b", "--", "./target", "@@"}, []string{"a", "b"}, []string{"./target", "@@"}},
+		{"no inputs", []string{"-mutate", "--", "./target", "@@"}, []string{"./target", "@@"}, nil, []string{"./target", "@@"}},
+		{"command dash dash", []string{"-mutate", "--", "./target", "--", "x"}, []string{"./target", "--", "x"}, nil, []string{"./target", "--", "x"}},
+		{"inputs and command dash dash", []string{"-mutate", "a", "--", "./target", "--", "x"}, []string{"a", "--", "./target", "--", "x"}, []string{"a"}, []string{"./target", "--", "x"}},
+		{"only dash dash", []string{"--", "./target"}, []string{"./target"}, nil, []string{"./target"}},
+		{"no dash dash", []string{"-mutate", "./target"}, []string{"./target"}, nil, []string{"./target"}},
+		{"nothing", []string{"-mutate", "--"}, []string{}, nil, []string{}},
+	}
+	for _, test := range tests {
+		inputs, command := SplitCommand(test.rawArgs, test.args)
+		if len(inputs) != len(test.inputs) || (len(inputs) > 0 && !reflect.DeepEqual(inputs, test.inputs)) {
+			t.Errorf("%s: expected the inputs %q, got %q", test.name, test.inputs, inputs)
+		}
+		if len(command) != len(test.command) || (len(command) > 0 && !reflect.DeepEqual(command, test.command)) {
+			t.Errorf("%s: expected the command %q, got %q", test.name, test.command, command)
+		}
+	}
+}
+
+func TestParseExitCodes(t *testing.T) {
+	tests := []struct {
+		text string
+		codes map[int]bool
+		ok bool
+	}{
+		{"0", map[int]bool{0: true}, true},
+		{"0,1", map[int]bool{0: true, 1: true}, true},
+		{" 0 , 77 ", map[int]bool{0: true, 77: true}, true},
+		{"-1", map[int]bool{-1: true}, true},
+		{"", nil, false},
+		{"0,", nil, false},
+		{"0,,1", nil, false},
+		{"ok", nil, false},
+		{"1-3", nil, false},
+		{"0;1", nil, false},
+	}
+	for _, test := range tests {
+		codes, err := ParseExitCodes(test.text)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.text, codes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(codes, test.codes) {
+			t.Errorf("%q: expected %v, got %v", test.text, test.codes, codes)
+		}
+	}
+}